@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"github.com/randall77/hprof/read"
+	"log"
+	"os"
+	"strconv"
+)
+
+var (
+	addr = flag.String("addr", "", "address of the object whose closure to extract")
+)
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 2 || *addr == "" {
+		log.Fatal("usage: dumpextract -addr=0x... heapdump outfile")
+	}
+	a, err := strconv.ParseUint(*addr, 0, 64)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d, err := read.Read(args[0], "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	x := d.FindObj(a)
+	if x == read.ObjNil {
+		log.Fatalf("no object at %#x", a)
+	}
+
+	f, err := os.Create(args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := d.WriteSubDump(f, x); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
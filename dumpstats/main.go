@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/randall77/hprof/read"
+	"log"
+	"sort"
+)
+
+var (
+	top      = flag.Int("top", 50, "number of types to report in the histogram")
+	by       = flag.String("by", "shallow", "order of the histogram: shallow, retained, or count")
+	hogs     = flag.Int("hogs", 20, "number of memory hogs to report")
+	validate = flag.Bool("validate", false, "check that every edge lands inside its target object before reporting")
+)
+
+// A typeStats is a row of the type histogram.
+type typeStats struct {
+	name     string
+	count    int
+	shallow  uint64 // bytes in the instances themselves
+	retained uint64 // bytes retained by the instances together
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	var d *read.Dump
+	var err error
+	switch len(args) {
+	case 1:
+		d, err = read.Read(args[0], "")
+	case 2:
+		d, err = read.Read(args[0], args[1])
+	default:
+		log.Fatal("usage: dumpstats [flags] heapdump [executable]")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *validate {
+		if err := d.Validate(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Histogram of types.
+	stats := make([]typeStats, len(d.FTList))
+	for i, ft := range d.FTList {
+		stats[i].name = ft.Name
+	}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		s := &stats[d.Ft(x).Id]
+		s.count++
+		s.shallow += d.Size(x)
+	}
+	for i, r := range d.RetainedByType() {
+		stats[i].retained = r
+	}
+	switch *by {
+	case "shallow":
+		sort.Stable(byShallow(stats))
+	case "retained":
+		sort.Stable(byRetained(stats))
+	case "count":
+		sort.Stable(byCount(stats))
+	default:
+		log.Fatalf("unknown histogram order %q", *by)
+	}
+	fmt.Printf("%12s %16s %16s  %s\n", "count", "bytes", "retained", "type")
+	n := 0
+	for _, s := range stats {
+		if n == *top {
+			break
+		}
+		if s.count == 0 {
+			continue
+		}
+		n++
+		fmt.Printf("%12d %16d %16d  %s\n", s.count, s.shallow, s.retained, s.name)
+	}
+
+	// Objects retaining the most memory, not nested inside each other.
+	if *hogs > 0 {
+		fmt.Println()
+		fmt.Printf("%16s %16s %18s  %s\n", "retained", "size", "address", "type")
+		for _, x := range d.Hogs(*hogs) {
+			fmt.Printf("%16d %16d %#18x  %s\n", d.RetainedSize(x), d.Size(x), d.Addr(x), d.Ft(x).Name)
+		}
+	}
+}
+
+type byShallow []typeStats
+
+func (a byShallow) Len() int           { return len(a) }
+func (a byShallow) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byShallow) Less(i, j int) bool { return a[i].shallow > a[j].shallow }
+
+type byRetained []typeStats
+
+func (a byRetained) Len() int           { return len(a) }
+func (a byRetained) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byRetained) Less(i, j int) bool { return a[i].retained > a[j].retained }
+
+type byCount []typeStats
+
+func (a byCount) Len() int           { return len(a) }
+func (a byCount) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byCount) Less(i, j int) bool { return a[i].count > a[j].count }
@@ -0,0 +1,109 @@
+package main
+
+// Prints the contents of every string in a heap dump, one per line,
+// along with its length and the object or global holding its header.
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/randall77/hprof/read"
+	"log"
+	"os"
+	"regexp"
+)
+
+var (
+	minLen = flag.Uint64("min", 1, "omit strings shorter than this")
+	maxLen = flag.Uint64("max", 0, "omit strings longer than this, if nonzero")
+	match  = flag.String("match", "", "print only strings matching this regexp")
+)
+
+// A stringRef is a string header and the contents it points to.
+type stringRef struct {
+	off uint64     // offset of the header in its object or global section
+	obj read.ObjId // object holding the string contents
+	ptr uint64     // offset of the contents in obj
+	len uint64     // length of the string
+}
+
+var (
+	d  *read.Dump
+	re *regexp.Regexp
+	w  *bufio.Writer
+)
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	var err error
+	switch len(args) {
+	case 1:
+		d, err = read.Read(args[0], "")
+	case 2:
+		d, err = read.Read(args[0], args[1])
+	default:
+		log.Fatal("usage: dumpstrings [flags] heapdump [executable]")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *match != "" {
+		re, err = regexp.Compile(*match)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	w = bufio.NewWriter(os.Stdout)
+
+	for _, x := range []*read.Data{d.Data, d.Bss} {
+		for _, r := range stringRefs(nil, x.Data, x.Edges) {
+			printString(fmt.Sprintf("global %x", x.Addr+r.off), r)
+		}
+	}
+	var refs []stringRef
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		// Collect the headers first; reading their contents
+		// reuses the buffer holding x.  Edges reads x too, so
+		// it goes before Contents.
+		edges := d.Edges(x)
+		refs = stringRefs(refs[:0], d.Contents(x), edges)
+		for _, r := range refs {
+			printString(fmt.Sprintf("%x+%d %s", d.Addr(x), r.off, d.Ft(x).Name), r)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// stringRefs appends to refs the string headers among edges whose
+// contents lie in the heap.  data holds the contents of the object or
+// global section the edges come from.
+func stringRefs(refs []stringRef, data []byte, edges []read.Edge) []stringRef {
+	for _, e := range edges {
+		if e.Kind != read.FieldKindString {
+			continue
+		}
+		n := d.ReadPtr(data[e.FromOffset+d.PtrSize:])
+		if n < *minLen || *maxLen != 0 && n > *maxLen {
+			continue
+		}
+		if e.ToOffset+n > d.Size(e.To) {
+			continue // runs off the end of its object; not a string
+		}
+		refs = append(refs, stringRef{e.FromOffset, e.To, e.ToOffset, n})
+	}
+	return refs
+}
+
+// printString writes the string r refers to, if it passes the filter, as
+// owner, length, and quoted contents.
+func printString(owner string, r stringRef) {
+	s := d.ContentsPrefix(r.obj, r.ptr+r.len)[r.ptr:]
+	if re != nil && !re.Match(s) {
+		return
+	}
+	fmt.Fprintf(w, "%s\t%d\t%q\n", owner, r.len, s)
+}
@@ -1,63 +1,214 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/randall77/hprof/read"
+	"log"
+	"os"
+	"sort"
+	"strings"
 )
 
+var (
+	onlyReachable = flag.Bool("onlyreachable", false, "omit unreachable objects from the graph")
+	ptrOnly       = flag.Bool("ptronly", false, "omit edges from slice, string, and interface fields")
+	format        = flag.String("format", "dot", "output format: dot, json, or csr (binary adjacency of all objects, see read.WriteCSR)")
+	types         = flag.Bool("types", false, "draw the graph of types instead, with edges weighted by the number of pointers between their instances")
+	roots         = flag.String("roots", "stacks,globals,other,finalizers", "comma-separated kinds of roots to draw and compute reachability from")
+	addrs         = flag.Bool("addrs", false, "show object addresses in node labels, and name nodes by address instead of object id")
+	labels        = flag.String("labels", "", "file of address<tab>label lines naming objects, shown in their nodes")
+)
+
+// rootKinds maps the names accepted by -roots to root categories.
+var rootKinds = map[string]string{
+	"stacks":     read.RootStacks,
+	"globals":    read.RootGlobals,
+	"other":      read.RootOther,
+	"finalizers": read.RootFinalizers,
+}
+
 func main() {
 	flag.Parse()
 	args := flag.Args()
 	var d *read.Dump
+	var err error
 	if len(args) == 2 {
-		d = read.Read(args[0], args[1])
+		d, err = read.Read(args[0], args[1])
 	} else {
-		d = read.Read(args[0], "")
+		d, err = read.Read(args[0], "")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *labels != "" {
+		if err := d.LoadLabels(*labels); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	// eliminate unreachable objects
-	// TODO: have reader do this?
-	reachable := make([]bool, d.NumObjects())
-	var q []read.ObjId
-	for _, f := range d.Frames {
-		for _, e := range f.Edges {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
+	selected := selectRoots(strings.Split(*roots, ","))
+	reachable := reachableFrom(d, selected)
+
+	switch *format {
+	case "dot":
+		if *types {
+			writeTypeGraph(d, reachable)
+			return
+		}
+	case "json":
+		writeJSON(d, selected, reachable)
+		return
+	case "csr":
+		if err := d.WriteCSR(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	default:
+		log.Fatalf("unknown format %q", *format)
+	}
+
+	fmt.Printf("digraph {\n")
+
+	// print object graph
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		if !reachable[x] && *onlyReachable {
+			continue
+		}
+		if !reachable[x] {
+			fmt.Printf("  %s [style=filled fillcolor=gray];\n", nodeId(d, x))
+		}
+		label := fmt.Sprintf("%s\n%d", d.Ft(x).Name, d.Size(x))
+		if *addrs {
+			label += fmt.Sprintf("\n%x", d.Addr(x))
+		}
+		if l := d.Label(x); l != "" {
+			label += "\n" + l
+		}
+		fmt.Printf("  %s [label=%s];\n", nodeId(d, x), read.DotQuote(label))
+		edges := d.Edges(x)
+		data := d.Contents(x)
+		for _, e := range edges {
+			if *ptrOnly && e.Kind != read.FieldKindPtr {
+				continue
+			}
+			var headlabel string
+			taillabel := tailLabel(d, data, e)
+			if e.ToOffset != 0 {
+				headlabel = fmt.Sprintf(" [headlabel=\"%d\"]", e.ToOffset)
 			}
+			fmt.Printf("  %s -> %s%s%s;\n", nodeId(d, x), nodeId(d, e.To), taillabel, headlabel)
 		}
 	}
-	for _, x := range []*read.Data{d.Data, d.Bss} {
-		for _, e := range x.Edges {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
+
+	// goroutines and stacks
+	if selected[read.RootStacks] {
+		for _, t := range d.Goroutines {
+			fmt.Printf("  \"goroutines\" [shape=diamond];\n")
+			fmt.Printf("  \"goroutines\" -> f%x_0;\n", t.Bos.Addr)
+		}
+		// stack frames
+		for _, f := range d.Frames {
+			fmt.Printf("  f%x_%d [label=%s shape=rectangle];\n", f.Addr, f.Depth, read.DotQuote(fmt.Sprintf("%s\n%d", f.Name, len(f.Data))))
+			if f.Parent != nil {
+				fmt.Printf("  f%x_%d -> f%x_%d;\n", f.Addr, f.Depth, f.Parent.Addr, f.Parent.Depth)
+			}
+			for _, e := range f.Edges {
+				if drawn(e.To, reachable) {
+					var headlabel string
+					taillabel := tailLabel(d, f.Data, e)
+					if e.ToOffset != 0 {
+						headlabel = fmt.Sprintf(" [headlabel=\"%d\"]", e.ToOffset)
+					}
+					fmt.Printf("  f%x_%d -> %s%s%s;\n", f.Addr, f.Depth, nodeId(d, e.To), taillabel, headlabel)
+				}
 			}
 		}
 	}
-	for _, r := range d.Otherroots {
-		for _, e := range r.Edges {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
+	if selected[read.RootGlobals] {
+		for _, x := range []*read.Data{d.Data, d.Bss} {
+			for _, e := range x.Edges {
+				if drawn(e.To, reachable) {
+					var headlabel string
+					if e.ToOffset != 0 {
+						headlabel = fmt.Sprintf(" [headlabel=\"%d\"]", e.ToOffset)
+					}
+					fmt.Printf("  %s [shape=diamond];\n", read.DotQuote(e.FieldName))
+					fmt.Printf("  %s -> %s%s;\n", read.DotQuote(e.FieldName), nodeId(d, e.To), headlabel)
+				}
 			}
 		}
 	}
-	for _, f := range d.QFinal {
-		for _, e := range f.Edges {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
+	if selected[read.RootOther] {
+		for _, r := range d.Otherroots {
+			for _, e := range r.Edges {
+				if !drawn(e.To, reachable) {
+					continue
+				}
+				var headlabel string
+				if e.ToOffset != 0 {
+					headlabel = fmt.Sprintf(" [headlabel=\"%d\"]", e.ToOffset)
+				}
+				fmt.Printf("  %s [shape=diamond];\n", read.DotQuote(r.Description))
+				fmt.Printf("  %s -> %s%s;\n", read.DotQuote(r.Description), nodeId(d, e.To), headlabel)
 			}
+		}
+	}
+	if selected[read.RootFinalizers] {
+		for _, f := range d.QFinal {
+			for _, e := range f.Edges {
+				if !drawn(e.To, reachable) {
+					continue
+				}
+				var headlabel string
+				if e.ToOffset != 0 {
+					headlabel = fmt.Sprintf(" [headlabel=\"%d\"]", e.ToOffset)
+				}
+				fmt.Printf("  \"queued finalizers\" [shape=diamond];\n")
+				fmt.Printf("  \"queued finalizers\" -> %s%s;\n", nodeId(d, e.To), headlabel)
+			}
+		}
+	}
+
+	fmt.Printf("}\n")
+}
 
+// drawn reports whether object x has a node in the graph, that is,
+// whether edges to it are drawn.
+func drawn(x read.ObjId, reachable []bool) bool {
+	return x != read.ObjNil && (reachable[x] || !*onlyReachable)
+}
+
+// selectRoots returns the set of root categories named by kinds, the
+// values of -roots.
+func selectRoots(kinds []string) map[string]bool {
+	selected := map[string]bool{}
+	for _, k := range kinds {
+		kind, ok := rootKinds[k]
+		if !ok {
+			log.Fatalf("unknown root kind %q", k)
 		}
+		selected[kind] = true
+	}
+	return selected
+}
+
+// reachableFrom returns a bitmap, indexed by ObjId, of the objects
+// reachable from the selected categories of roots.
+func reachableFrom(d *read.Dump, selected map[string]bool) []bool {
+	if len(selected) == len(rootKinds) {
+		return d.Reachable()
 	}
-	for _, g := range d.Goroutines {
-		if g.Ctxt != read.ObjNil {
-			if !reachable[g.Ctxt] {
-				reachable[g.Ctxt] = true
-				q = append(q, g.Ctxt)
+
+	reachable := make([]bool, d.NumObjects())
+	var q []read.ObjId
+	for kind := range selected {
+		for _, x := range d.RootObjects(kind) {
+			if !reachable[x] {
+				reachable[x] = true
+				q = append(q, x)
 			}
 		}
 	}
@@ -71,89 +222,188 @@ func main() {
 			}
 		}
 	}
+	return reachable
+}
+
+// writeTypeGraph prints the graph of types in dot format.  There is
+// an edge from type A to type B if an instance of A points to an
+// instance of B, labeled with the number of such pointers.
+func writeTypeGraph(d *read.Dump, reachable []bool) {
+	count := map[int]int{}
+	weight := map[typeEdge]int{}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		if !reachable[x] && *onlyReachable {
+			continue
+		}
+		t := d.Ft(x).Id
+		count[t]++
+		for _, e := range d.Edges(x) {
+			if *ptrOnly && e.Kind != read.FieldKindPtr {
+				continue
+			}
+			weight[typeEdge{t, d.Ft(e.To).Id}]++
+		}
+	}
 
 	fmt.Printf("digraph {\n")
+	for _, ft := range d.FTList {
+		if count[ft.Id] > 0 {
+			fmt.Printf("  t%d [label=%s];\n", ft.Id, read.DotQuote(fmt.Sprintf("%s\n%d", ft.Name, count[ft.Id])))
+		}
+	}
+	var edges []typeEdge
+	for e := range weight {
+		edges = append(edges, e)
+	}
+	sort.Sort(byTypes(edges))
+	for _, e := range edges {
+		fmt.Printf("  t%d -> t%d [label=\"%d\"];\n", e.from, e.to, weight[e])
+	}
+	fmt.Printf("}\n")
+}
 
-	// print object graph
+// A typeEdge is an edge of the type graph, between FullType ids.
+type typeEdge struct {
+	from, to int
+}
+
+type byTypes []typeEdge
+
+func (a byTypes) Len() int      { return len(a) }
+func (a byTypes) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byTypes) Less(i, j int) bool {
+	if a[i].from != a[j].from {
+		return a[i].from < a[j].from
+	}
+	return a[i].to < a[j].to
+}
+
+// tailLabel returns the dot attribute labeling the source end of edge e,
+// which leaves an object with the given contents.
+func tailLabel(d *read.Dump, data []byte, e read.Edge) string {
+	label := edgeLabel(d, data, e)
+	if label == "" {
+		return ""
+	}
+	return fmt.Sprintf(" [taillabel=%s]", read.DotQuote(label))
+}
+
+// edgeLabel returns the label of edge e, which leaves an object with
+// the given contents.  Edges to slice and string backing stores are
+// marked with [] or str and their len/cap, to distinguish them from
+// ordinary pointers.
+func edgeLabel(d *read.Dump, data []byte, e read.Edge) string {
+	label := e.FieldName
+	if label == "" && e.FromOffset != 0 {
+		label = fmt.Sprintf("%d", e.FromOffset)
+	}
+	switch e.Kind {
+	case read.FieldKindSlice:
+		label = fmt.Sprintf("%s []%d/%d", label, d.ReadPtr(data[e.FromOffset+d.PtrSize:]), d.ReadPtr(data[e.FromOffset+2*d.PtrSize:]))
+	case read.FieldKindString:
+		label = fmt.Sprintf("%s str/%d", label, d.ReadPtr(data[e.FromOffset+d.PtrSize:]))
+	}
+	return label
+}
+
+type jsonNode struct {
+	Id    string `json:"id"`
+	Label string `json:"label"`
+	Size  uint64 `json:"size"`
+	Type  string `json:"type"`
+}
+
+type jsonEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Label string `json:"label"`
+}
+
+// nodeId returns the name of object x's node: v followed by its object
+// id, which is the id hview uses, or with -addrs, x followed by its
+// address, which is the same in every tool.
+func nodeId(d *read.Dump, x read.ObjId) string {
+	if *addrs {
+		return fmt.Sprintf("x%x", d.Addr(x))
+	}
+	return fmt.Sprintf("v%d", x)
+}
+
+type jsonGraph struct {
+	Nodes []jsonNode `json:"nodes"`
+	Edges []jsonEdge `json:"edges"`
+}
+
+// writeJSON writes the same graph as the dot output, as a JSON object
+// holding lists of nodes and edges, for browser-based viewers.  Object
+// nodes have type "object" and are named as in the dot output; roots
+// have type "frame", "global", "other", or "finalizer".
+func writeJSON(d *read.Dump, selected map[string]bool, reachable []bool) {
+	var g jsonGraph
 	for i := 0; i < d.NumObjects(); i++ {
 		x := read.ObjId(i)
-		if !reachable[x] {
-			fmt.Printf("  v%d [style=filled fillcolor=gray];\n", x)
+		if !reachable[x] && *onlyReachable {
+			continue
 		}
-		fmt.Printf("  v%d [label=\"%s\\n%d\"];\n", x, d.Ft(x).Name, d.Size(x))
+		id := nodeId(d, x)
+		g.Nodes = append(g.Nodes, jsonNode{id, d.Ft(x).Name, d.Size(x), "object"})
+		data := d.Contents(x)
 		for _, e := range d.Edges(x) {
-			var taillabel, headlabel string
-			if e.FieldName != "" {
-				taillabel = fmt.Sprintf(" [taillabel=\"%s\"]", e.FieldName)
-			} else if e.FromOffset != 0 {
-				taillabel = fmt.Sprintf(" [taillabel=\"%d\"]", e.FromOffset)
+			if *ptrOnly && e.Kind != read.FieldKindPtr {
+				continue
 			}
-			if e.ToOffset != 0 {
-				headlabel = fmt.Sprintf(" [headlabel=\"%d\"]", e.ToOffset)
-			}
-			fmt.Printf("  v%d -> v%d%s%s;\n", x, e.To, taillabel, headlabel)
+			g.Edges = append(g.Edges, jsonEdge{id, nodeId(d, e.To), edgeLabel(d, data, e)})
 		}
 	}
-
-	// goroutines and stacks
-	for _, t := range d.Goroutines {
-		fmt.Printf("  \"goroutines\" [shape=diamond];\n")
-		fmt.Printf("  \"goroutines\" -> f%x_0;\n", t.Bos.Addr)
-	}
-
-	// stack frames
-	for _, f := range d.Frames {
-		fmt.Printf("  f%x_%d [label=\"%s\\n%d\" shape=rectangle];\n", f.Addr, f.Depth, f.Name, len(f.Data))
-		if f.Parent != nil {
-			fmt.Printf("  f%x_%d -> f%x_%d;\n", f.Addr, f.Depth, f.Parent.Addr, f.Parent.Depth)
-		}
-		for _, e := range f.Edges {
-			if e.To != read.ObjNil {
-				var taillabel, headlabel string
-				if e.FieldName != "" {
-					taillabel = fmt.Sprintf(" [taillabel=\"%s\"]", e.FieldName)
-				} else if e.FromOffset != 0 {
-					taillabel = fmt.Sprintf(" [taillabel=\"%d\"]", e.FromOffset)
-				}
-				if e.ToOffset != 0 {
-					headlabel = fmt.Sprintf(" [headlabel=\"%d\"]", e.ToOffset)
+	if selected[read.RootStacks] {
+		for _, f := range d.Frames {
+			id := fmt.Sprintf("f%x_%d", f.Addr, f.Depth)
+			g.Nodes = append(g.Nodes, jsonNode{id, f.Name, uint64(len(f.Data)), "frame"})
+			if f.Parent != nil {
+				g.Edges = append(g.Edges, jsonEdge{id, fmt.Sprintf("f%x_%d", f.Parent.Addr, f.Parent.Depth), ""})
+			}
+			for _, e := range f.Edges {
+				if !drawn(e.To, reachable) {
+					continue
 				}
-				fmt.Printf("  f%x_%d -> v%d%s%s;\n", f.Addr, f.Depth, e.To, taillabel, headlabel)
+				g.Edges = append(g.Edges, jsonEdge{id, nodeId(d, e.To), edgeLabel(d, f.Data, e)})
 			}
 		}
 	}
-	for _, x := range []*read.Data{d.Data, d.Bss} {
-		for _, e := range x.Edges {
-			if e.To != read.ObjNil {
-				var headlabel string
-				if e.ToOffset != 0 {
-					headlabel = fmt.Sprintf(" [headlabel=\"%d\"]", e.ToOffset)
-				}
-				fmt.Printf("  \"%s\" [shape=diamond];\n", e.FieldName)
-				fmt.Printf("  \"%s\" -> v%d%s;\n", e.FieldName, e.To, headlabel)
+	roots := map[string]bool{}
+	root := func(name, typ string, e read.Edge) {
+		if !drawn(e.To, reachable) {
+			return
+		}
+		if !roots[name] {
+			roots[name] = true
+			g.Nodes = append(g.Nodes, jsonNode{name, name, 0, typ})
+		}
+		g.Edges = append(g.Edges, jsonEdge{name, nodeId(d, e.To), ""})
+	}
+	if selected[read.RootGlobals] {
+		for _, x := range []*read.Data{d.Data, d.Bss} {
+			for _, e := range x.Edges {
+				root(e.FieldName, "global", e)
 			}
 		}
 	}
-	for _, r := range d.Otherroots {
-		for _, e := range r.Edges {
-			var headlabel string
-			if e.ToOffset != 0 {
-				headlabel = fmt.Sprintf(" [headlabel=\"%d\"]", e.ToOffset)
+	if selected[read.RootOther] {
+		for _, r := range d.Otherroots {
+			for _, e := range r.Edges {
+				root(r.Description, "other", e)
 			}
-			fmt.Printf("  \"%s\" [shape=diamond];\n", r.Description)
-			fmt.Printf("  \"%s\" -> v%d%s;\n", r.Description, e.To, headlabel)
 		}
 	}
-	for _, f := range d.QFinal {
-		for _, e := range f.Edges {
-			var headlabel string
-			if e.ToOffset != 0 {
-				headlabel = fmt.Sprintf(" [headlabel=\"%d\"]", e.ToOffset)
+	if selected[read.RootFinalizers] {
+		for _, f := range d.QFinal {
+			for _, e := range f.Edges {
+				root("queued finalizers", "finalizer", e)
 			}
-			fmt.Printf("  \"queued finalizers\" [shape=diamond];\n")
-			fmt.Printf("  \"queued finalizers\" -> v%d%s;\n", e.To, headlabel)
 		}
 	}
-
-	fmt.Printf("}\n")
+	if err := json.NewEncoder(os.Stdout).Encode(&g); err != nil {
+		log.Fatal(err)
+	}
 }
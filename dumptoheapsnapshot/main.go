@@ -0,0 +1,213 @@
+package main
+
+// Converts a Go heap dump to the V8 .heapsnapshot format, which the
+// Memory panel of Chrome DevTools can load.
+// https://developer.chrome.com/docs/devtools/memory-problems/heap-snapshots
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/randall77/hprof/read"
+	"log"
+	"os"
+	"strconv"
+)
+
+// snapshot metadata.  The node and edge types below are indexes into
+// the type lists here.
+const meta = `{"node_fields":["type","name","id","self_size","edge_count","trace_node_id"],` +
+	`"node_types":[["hidden","array","string","object","code","closure","regexp","number","native","synthetic","concatenated string","sliced string"],"string","number","number","number","number"],` +
+	`"edge_fields":["type","name_or_index","to_node"],` +
+	`"edge_types":[["context","element","property","internal","hidden","shortcut","weak"],"string_or_number","node"],` +
+	`"trace_function_info_fields":[],"trace_node_fields":[],"sample_fields":[],"location_fields":[]}`
+
+const (
+	nodeObject    = 3
+	nodeSynthetic = 9
+
+	edgeElement  = 1
+	edgeProperty = 2
+
+	nodeFieldCount = 6 // entries per node in the nodes array
+)
+
+// A rootEdge is an edge from a synthetic root node to an object.
+type rootEdge struct {
+	name string
+	to   read.ObjId
+}
+
+// A rootGroup is a synthetic node holding the roots of one kind.
+type rootGroup struct {
+	name  string
+	edges []rootEdge
+}
+
+var (
+	strs   []string
+	strIdx = map[string]int{}
+)
+
+// str returns the index of s in the snapshot's string table.
+func str(s string) int {
+	if i, ok := strIdx[s]; ok {
+		return i
+	}
+	strIdx[s] = len(strs)
+	strs = append(strs, s)
+	return len(strs) - 1
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	var d *read.Dump
+	var outfile string
+	var err error
+	switch len(args) {
+	case 2:
+		d, err = read.Read(args[0], "")
+		outfile = args[1]
+	case 3:
+		d, err = read.Read(args[0], args[1])
+		outfile = args[2]
+	default:
+		log.Fatal("usage: dumptoheapsnapshot heapdump [executable] outfile")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	groups := rootGroups(d)
+
+	file, err := os.Create(outfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := bufio.NewWriter(file)
+
+	// Node 0 is the root DevTools computes retained sizes from.  It
+	// points to a synthetic node for each kind of root, which is
+	// followed by the heap objects.  Object x is node 1+len(groups)+x.
+	nnodes := 1 + len(groups) + d.NumObjects()
+	nedges := len(groups)
+	for _, g := range groups {
+		nedges += len(g.edges)
+	}
+	for i := 0; i < d.NumObjects(); i++ {
+		nedges += len(d.Edges(read.ObjId(i)))
+	}
+	objNode := func(x read.ObjId) int {
+		return (1 + len(groups) + int(x)) * nodeFieldCount
+	}
+
+	fmt.Fprintf(w, `{"snapshot":{"meta":%s,"node_count":%d,"edge_count":%d,"trace_function_count":0},`, meta, nnodes, nedges)
+
+	// nodes: type, name, id, self_size, edge_count, trace_node_id.
+	// Synthetic nodes get small odd ids, objects their address.
+	w.WriteString("\n\"nodes\":[")
+	fmt.Fprintf(w, "%d,%d,%d,0,%d,0", nodeSynthetic, str("(GC roots)"), 1, len(groups))
+	for i, g := range groups {
+		fmt.Fprintf(w, ",\n%d,%d,%d,0,%d,0", nodeSynthetic, str(g.name), 2*i+3, len(g.edges))
+	}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		fmt.Fprintf(w, ",\n%d,%d,%d,%d,%d,0", nodeObject, str(d.Ft(x).Name), d.Addr(x), d.Size(x), len(d.Edges(x)))
+	}
+	w.WriteString("],")
+
+	// edges: type, name_or_index, to_node.  They are listed in the
+	// order of the nodes they come from.  Fields with no name are
+	// elements indexed by their offset.
+	w.WriteString("\n\"edges\":[")
+	for i := range groups {
+		if i > 0 {
+			w.WriteString(",")
+		}
+		fmt.Fprintf(w, "\n%d,%d,%d", edgeElement, i, (1+i)*nodeFieldCount)
+	}
+	for _, g := range groups {
+		for _, e := range g.edges {
+			fmt.Fprintf(w, ",\n%d,%d,%d", edgeProperty, str(e.name), objNode(e.to))
+		}
+	}
+	for i := 0; i < d.NumObjects(); i++ {
+		for _, e := range d.Edges(read.ObjId(i)) {
+			if e.FieldName != "" {
+				fmt.Fprintf(w, ",\n%d,%d,%d", edgeProperty, str(e.FieldName), objNode(e.To))
+			} else {
+				fmt.Fprintf(w, ",\n%d,%d,%d", edgeElement, e.FromOffset, objNode(e.To))
+			}
+		}
+	}
+	w.WriteString("],")
+
+	w.WriteString("\n\"trace_function_infos\":[],\"trace_tree\":[],\"samples\":[],\"locations\":[],")
+
+	w.WriteString("\n\"strings\":[")
+	for i, s := range strs {
+		b, err := json.Marshal(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if i > 0 {
+			w.WriteString(",\n")
+		}
+		w.Write(b)
+	}
+	w.WriteString("]}\n")
+
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// rootGroups collects the edges from the roots of the dump, grouped by
+// kind of root, and named after the variable or description of the
+// root they come from.
+func rootGroups(d *read.Dump) []rootGroup {
+	stacks := rootGroup{name: "(" + read.RootStacks + ")"}
+	for _, f := range d.Frames {
+		for _, e := range f.Edges {
+			stacks.edges = append(stacks.edges, rootEdge{f.Name + "." + edgeName(e), e.To})
+		}
+	}
+	for _, g := range d.Goroutines {
+		if g.Ctxt != read.ObjNil {
+			stacks.edges = append(stacks.edges, rootEdge{fmt.Sprintf("goroutine %x context", g.Addr), g.Ctxt})
+		}
+	}
+	globals := rootGroup{name: "(" + read.RootGlobals + ")"}
+	for _, x := range []*read.Data{d.Data, d.Bss} {
+		for _, e := range x.Edges {
+			globals.edges = append(globals.edges, rootEdge{edgeName(e), e.To})
+		}
+	}
+	other := rootGroup{name: "(" + read.RootOther + ")"}
+	for _, x := range d.Otherroots {
+		for _, e := range x.Edges {
+			other.edges = append(other.edges, rootEdge{x.Description, e.To})
+		}
+	}
+	finalizers := rootGroup{name: "(" + read.RootFinalizers + ")"}
+	for _, f := range d.QFinal {
+		for _, e := range f.Edges {
+			finalizers.edges = append(finalizers.edges, rootEdge{edgeName(e), e.To})
+		}
+	}
+	return []rootGroup{stacks, globals, other, finalizers}
+}
+
+// edgeName returns the name of the field e comes from, or its offset
+// if it has none.
+func edgeName(e read.Edge) string {
+	if e.FieldName != "" {
+		return e.FieldName
+	}
+	return "+" + strconv.FormatUint(e.FromOffset, 10)
+}
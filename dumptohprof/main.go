@@ -10,8 +10,12 @@ import (
 	"github.com/randall77/hprof/read"
 	"log"
 	"os"
+	"sort"
+	"strings"
 )
 
+var nameMap = flag.String("namemap", "", "write the mapping from Java class names to Go type names to this file")
+
 // hprof constants
 const (
 	HPROF_UTF8         = 1
@@ -93,6 +97,10 @@ var dump []byte
 // cache of strings already generated
 var stringCache map[string]uint64
 
+// map from Go type name to the Java class name it is written as, and back
+var javaNames = map[string]string{}
+var goNames = map[string]string{}
+
 // map from threads to thread serial numbers
 var threadSerialNumbers map[*read.GoRoutine]uint32
 var stackTraceSerialNumbers map[*read.GoRoutine]uint32
@@ -101,15 +109,50 @@ func main() {
 	flag.Parse()
 	args := flag.Args()
 	var outfile string
+	var err error
 	if len(args) == 2 {
-		d = read.Read(args[0], "")
+		d, err = read.Read(args[0], "")
 		outfile = args[1]
 	} else {
-		d = read.Read(args[0], args[1])
+		d, err = read.Read(args[0], args[1])
 		outfile = args[2]
 	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := convert(); err != nil {
+		log.Fatal(err)
+	}
+
+	// write final file to output
+	file, err := os.Create(outfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	file.Write(hprof)
+	file.Close()
+
+	if *nameMap != "" {
+		writeNameMap(*nameMap)
+	}
+}
 
+// convert converts the heap dump d to hprof format, leaving the
+// result in hprof.  It starts over each time it is called.
+func convert() error {
 	// some setup
+	hprof = nil
+	dump = nil
+	idAlloc = 104
+	serialAlloc = 100
+	javaNames = map[string]string{}
+	goNames = map[string]string{}
+	javaFields = make(map[uint64][]JavaField, 0)
+	stdClass = make(map[uint64]uint64, 0)
+	noPtrClass = make(map[uint64]uint64, 0)
+	arrayClass = make(map[ArrayKey]uint64, 0)
+	chanClass = make(map[ChanKey]uint64, 0)
 	usedIds = make(map[uint64]struct{}, 0)
 	for _, typ := range d.Types {
 		usedIds[typ.Addr] = struct{}{}
@@ -148,15 +191,58 @@ func main() {
 	addThreads()
 
 	// the full heap is one big tag
-	addHeapDump()
+	return addHeapDump()
+}
 
-	// write final file to output
-	file, err := os.Create(outfile)
+// javaName returns a class name for the Go name, acceptable to Java
+// tools.  Package path separators become dots, other characters that
+// can't appear in a Java identifier (as in array{10}T or *T) become
+// underscores, and a $N suffix keeps distinct Go names distinct.
+func javaName(name string) string {
+	if j, ok := javaNames[name]; ok {
+		return j
+	}
+	j := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '$', r == '.':
+			return r
+		case r == '/':
+			return '.'
+		}
+		return '_'
+	}, name)
+	base := j
+	for i := 1; ; i++ {
+		if _, ok := goNames[j]; !ok {
+			break
+		}
+		j = fmt.Sprintf("%s$%d", base, i)
+	}
+	javaNames[name] = j
+	goNames[j] = name
+	return j
+}
+
+// writeNameMap writes the Java class names that differ from the Go
+// names they stand for, one "java<tab>go" pair per line.
+func writeNameMap(filename string) {
+	var lines []string
+	for j, g := range goNames {
+		if j != g {
+			lines = append(lines, j+"\t"+g+"\n")
+		}
+	}
+	sort.Strings(lines)
+	f, err := os.Create(filename)
 	if err != nil {
 		log.Fatal(err)
 	}
-	file.Write(hprof)
-	file.Close()
+	for _, l := range lines {
+		f.WriteString(l)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 // temporary
@@ -306,7 +392,7 @@ func addClass(id uint64, size uint64, name string, fields []JavaField) {
 	body = append32(body, sid)
 	body = appendId(body, id)
 	body = append32(body, stack_trace_serial_number)
-	body = appendId(body, addString(name))
+	body = appendId(body, addString(javaName(name)))
 	addTag(HPROF_LOAD_CLASS, body)
 
 	// write a class dump subcommand
@@ -403,7 +489,7 @@ func addGlobal(name string, kind read.FieldKind, data []byte) {
 	body = append32(body, sid)
 	body = appendId(body, c)
 	body = append32(body, stack_trace_serial_number)
-	body = appendId(body, addString(name))
+	body = appendId(body, addString(javaName(name)))
 	addTag(HPROF_LOAD_CLASS, body)
 
 	// write a class dump subcommand
@@ -709,7 +795,9 @@ func ChanClass(t *read.Type, size uint64) uint64 {
 	return c
 }
 
-func addHeapDump() {
+// addHeapDump adds the heap dump tag.  It reports an error if an
+// object is too big to be recorded.
+func addHeapDump() error {
 	// a few fake class dumps to keep java tools happy
 	dump = append(dump, fakeClassDump(java_lang_object, 0)...)
 	dump = append(dump, fakeClassDump(java_lang_class, java_lang_object)...)
@@ -722,9 +810,10 @@ func addHeapDump() {
 	// output each object as an instance
 	for i := 0; i < d.NumObjects(); i++ {
 		x := read.ObjId(i)
-		if d.Size(x) >= 8<<32 {
-			// file format can't record objects this big.  TODO: error/warning?  Truncate?
-			continue
+		if d.Size(x) >= 1<<32 {
+			// The heap dump tag's length is 32 bits, so it can't
+			// hold an object this big, not even as an array.
+			return fmt.Errorf("object %x is too big for hprof: %d bytes", d.Addr(x), d.Size(x))
 		}
 
 		// figure out what class to use for this object
@@ -744,13 +833,17 @@ func addHeapDump() {
 				log.Fatal("unhandled kind")
 			}
 		}
+		if c == bigPtrArray {
+			addBigPtrObject(x)
+			continue
+		}
 
 		// make a copy of the object data so we can modify it
 		data = append(data[:0], d.Contents(x)...)
 
 		// Any pointers to objects get adjusted to point to the object head.
 		for _, e := range d.Edges(x) {
-			writePtr(data[e.FromOffset:], d.Addr(e.To))
+			d.WritePtr(data[e.FromOffset:], d.Addr(e.To))
 		}
 
 		// convert to big-endian representation
@@ -758,10 +851,6 @@ func addHeapDump() {
 			for i := uint64(0); i < uint64(len(data)); i += 8 {
 				bigEndian8(data[i:])
 			}
-		} else if c == bigPtrArray {
-			for i := uint64(0); i < uint64(len(data)); i += d.PtrSize {
-				bigEndianP(data[i:])
-			}
 		} else {
 			off := uint64(0)
 			for _, f := range javaFields[c] {
@@ -795,24 +884,22 @@ func addHeapDump() {
 		}
 
 		// dump object header
+		n := d.Size(x) // instance size, or array length
+		if c == bigNoPtrArray {
+			n /= 8
+		}
 		if c == bigNoPtrArray {
 			dump = append(dump, HPROF_GC_PRIM_ARRAY_DUMP)
 			dump = appendId(dump, d.Addr(x))
 			dump = append32(dump, stack_trace_serial_number)
-			dump = append32(dump, uint32(d.Size(x)/8))
+			dump = append32(dump, uint32(n))
 			dump = append(dump, T_LONG)
-		} else if c == bigPtrArray {
-			dump = append(dump, HPROF_GC_OBJ_ARRAY_DUMP)
-			dump = appendId(dump, d.Addr(x))
-			dump = append32(dump, stack_trace_serial_number)
-			dump = append32(dump, uint32(d.Size(x)/8))
-			dump = appendId(dump, java_lang_objectarray)
 		} else {
 			dump = append(dump, HPROF_GC_INSTANCE_DUMP)
 			dump = appendId(dump, d.Addr(x))
 			dump = append32(dump, stack_trace_serial_number)
 			dump = appendId(dump, c)
-			dump = append32(dump, uint32(d.Size(x)))
+			dump = append32(dump, uint32(n))
 		}
 		// dump object data
 		dump = append(dump, data...)
@@ -865,7 +952,7 @@ func addHeapDump() {
 	for _, x := range []*read.Data{d.Data, d.Bss} {
 		// adjust edges to point to object beginnings
 		for _, e := range x.Edges {
-			writePtr(x.Data[e.FromOffset:], d.Addr(e.To))
+			d.WritePtr(x.Data[e.FromOffset:], d.Addr(e.To))
 		}
 		for _, f := range x.Fields {
 			addGlobal(f.Name, f.Kind, x.Data[f.Offset:])
@@ -879,6 +966,53 @@ func addHeapDump() {
 	}
 
 	addTag(HPROF_HEAP_DUMP, dump)
+	return nil
+}
+
+// addBigPtrObject emits an object which has pointers but is too big
+// to be described by a class.  Its pointers are emitted as an object
+// array, so the reference graph stays correct.  Its remaining words
+// are emitted as a primitive array which the object array refers to
+// in its last slot.
+func addBigPtrObject(x read.ObjId) {
+	edges := d.Edges(x)
+	data := d.Contents(x)
+
+	// split the object into pointers and scalars
+	var ptrs []uint64
+	var scalars []byte
+	off := uint64(0)
+	for _, e := range edges {
+		scalars = append(scalars, data[off:e.FromOffset]...)
+		ptrs = append(ptrs, d.Addr(e.To))
+		off = e.FromOffset + d.PtrSize
+	}
+	scalars = append(scalars, data[off:]...)
+	for i := uint64(0); i < uint64(len(scalars)); i += d.PtrSize {
+		bigEndianP(scalars[i:])
+	}
+	kind := byte(T_LONG)
+	if d.PtrSize == 4 {
+		kind = T_INT
+	}
+
+	prim := newId()
+	dump = append(dump, HPROF_GC_PRIM_ARRAY_DUMP)
+	dump = appendId(dump, prim)
+	dump = append32(dump, stack_trace_serial_number)
+	dump = append32(dump, uint32(uint64(len(scalars))/d.PtrSize))
+	dump = append(dump, kind)
+	dump = append(dump, scalars...)
+
+	dump = append(dump, HPROF_GC_OBJ_ARRAY_DUMP)
+	dump = appendId(dump, d.Addr(x))
+	dump = append32(dump, stack_trace_serial_number)
+	dump = append32(dump, uint32(len(ptrs)+1))
+	dump = appendId(dump, java_lang_objectarray)
+	for _, p := range ptrs {
+		dump = appendId(dump, p)
+	}
+	dump = appendId(dump, prim)
 }
 
 // NOTE: hprof is a big-endian format
@@ -920,38 +1054,3 @@ func bigEndianP(x []byte) {
 		bigEndian8(x)
 	}
 }
-
-func writePtr(b []byte, v uint64) {
-	switch {
-	case d.Order == binary.LittleEndian && d.PtrSize == 4:
-		b[0] = byte(v >> 0)
-		b[1] = byte(v >> 8)
-		b[2] = byte(v >> 16)
-		b[3] = byte(v >> 24)
-	case d.Order == binary.BigEndian && d.PtrSize == 4:
-		b[3] = byte(v >> 0)
-		b[2] = byte(v >> 8)
-		b[1] = byte(v >> 16)
-		b[0] = byte(v >> 24)
-	case d.Order == binary.LittleEndian && d.PtrSize == 8:
-		b[0] = byte(v >> 0)
-		b[1] = byte(v >> 8)
-		b[2] = byte(v >> 16)
-		b[3] = byte(v >> 24)
-		b[4] = byte(v >> 32)
-		b[5] = byte(v >> 40)
-		b[6] = byte(v >> 48)
-		b[7] = byte(v >> 56)
-	case d.Order == binary.BigEndian && d.PtrSize == 8:
-		b[7] = byte(v >> 0)
-		b[6] = byte(v >> 8)
-		b[5] = byte(v >> 16)
-		b[4] = byte(v >> 24)
-		b[3] = byte(v >> 32)
-		b[2] = byte(v >> 40)
-		b[1] = byte(v >> 48)
-		b[0] = byte(v >> 56)
-	default:
-		log.Fatal("unsupported order=%v PtrSize=%d", d.Order, d.PtrSize)
-	}
-}
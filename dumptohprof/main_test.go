@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/randall77/hprof/read"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readDump ends the dump with a root pointing to root and reads it.
+func readDump(t *testing.T, w *read.TestDump, root uint64) *read.Dump {
+	w.OtherRoot("root", root)
+	w.End()
+	x, err := read.ReadFrom(bytes.NewReader(w.Bytes()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+// instance returns the fields of the instance dump of the object at
+// addr in hprof.
+func instance(t *testing.T, addr uint64, size int) []byte {
+	hdr := appendId([]byte{HPROF_GC_INSTANCE_DUMP}, addr)
+	i := bytes.Index(hprof, hdr)
+	if i < 0 {
+		t.Fatalf("no instance dump of %#x", addr)
+	}
+	// tag, id, stack trace serial number, class id, length
+	i += 1 + 8 + 4 + 8
+	if n := binary.BigEndian.Uint32(hprof[i:]); n != uint32(size) {
+		t.Fatalf("instance of %#x has %d bytes, want %d", addr, n, size)
+	}
+	i += 4
+	return hprof[i : i+size]
+}
+
+func TestConvertByteOrder(t *testing.T) {
+	const (
+		h      = 0x10000
+		scalar = 0x0102030405060708
+	)
+	for _, tt := range []struct {
+		order   binary.ByteOrder
+		ptrSize uint64
+	}{
+		{binary.BigEndian, 8},
+		{binary.LittleEndian, 8},
+		{binary.BigEndian, 4},
+	} {
+		p := tt.ptrSize
+		x := uint64(scalar) >> (64 - 8*p) // scalar, cut to a word
+		w := read.NewTestDump(tt.order, p)
+		w.Header(h, h+0x1000)
+		w.Type(0x100, 2*p, "main.T", 0)
+		// an interior pointer, which is written as a pointer to the
+		// head of its target
+		w.Object(h, 0x100, read.TypeKindObject, w.Words(h+2*p+p, x))
+		w.Object(h+2*p, 0, read.TypeKindObject, w.Words(0, 0))
+		d = readDump(t, w, h)
+		if err := convert(); err != nil {
+			t.Fatal(err)
+		}
+
+		// hprof is big endian whatever the dump's byte order is
+		want := append64(append64(nil, h+2*p), x)
+		if p == 4 {
+			want = append32(append32(nil, uint32(h+2*p)), uint32(x))
+		}
+		if got := instance(t, h, int(2*p)); !bytes.Equal(got, want) {
+			t.Errorf("%v dump with %d-byte pointers: instance data %x, want %x", tt.order, p, got, want)
+		}
+	}
+}
+
+func TestConvertHugeObject(t *testing.T) {
+	const (
+		h    = 0x10000
+		size = 5 << 30
+	)
+	// The object's contents are left as a hole in the file.
+	w := read.NewTestDump(binary.LittleEndian, 8)
+	w.Header(h, h+size)
+	w.HugeObject(h, size)
+	w.OtherRoot("root", h)
+	w.End()
+	name := filepath.Join(t.TempDir(), "dump")
+	if err := w.WriteFile(name); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	d, err = read.Read(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = convert()
+	if err == nil || !strings.Contains(err.Error(), "too big") {
+		t.Errorf("converting a %d byte object: got error %v, want too big", uint64(size), err)
+	}
+}
@@ -1,55 +1,264 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/randall77/hprof/read"
 	"html"
+	"html/template"
+	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	httppprof "net/http/pprof"
+	"net/url"
 	"os"
+	"os/exec"
+	"regexp"
 	"runtime"
 	"runtime/debug"
+	"runtime/pprof"
 	"sort"
 	"strconv"
-	"text/template"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 const (
 	defaultAddr = ":8080" // default webserver address
-	maxFields   = 4096 + 1
 )
 
 var (
-	httpAddr = flag.String("http", defaultAddr, "HTTP service address")
+	httpAddr     = flag.String("http", defaultAddr, "HTTP service address")
+	cpuProfile   = flag.String("cpuprofile", "", "write a CPU profile of loading and analysis to this file")
+	memProfile   = flag.String("memprofile", "", "write a memory profile to this file after analysis")
+	skipNaming   = flag.String("skipnaming", "", "don't name fields of types whose names match this regexp")
+	verbose      = flag.Bool("v", false, "log mismatches between the DWARF info and the heap dump types")
+	maxFields    = flag.Int("maxfields", 4096, "maximum number of fields or search results shown on a page")
+	maxReferrers = flag.Int("maxreferrers", 4096, "maximum number of referrers shown for an object")
+	maxGlobals   = flag.Int("maxglobals", 65536, "maximum number of globals shown")
+	debugDir     = flag.String("debugdir", read.DebugDir, "global directory searched for separate debug info files named by .gnu_debuglink")
+	workers      = flag.Int("workers", 2, "maximum number of pages computed at once, across all heap dumps")
+	labels       = flag.String("labels", "", "file of address<tab>label lines naming objects in the first heap dump")
+	maxBytes     = flag.Uint64("maxbytes", 64<<10, "maximum number of bytes of an object shown")
+	fullFloats   = flag.Bool("fullfloats", false, "show floats at full precision, with their bits")
+	rodata       = flag.Bool("rodata", false, "show the string constants that pointers into the executable's read-only data point at")
+	dotTimeout   = flag.Duration("dottimeout", 30*time.Second, "maximum time graphviz may take to draw a neighborhood graph")
 )
 
-// d is the loaded heap dump.
-var d *read.Dump
+// A viewer holds a loaded heap dump and the results of analyzing it.
+type viewer struct {
+	id   int    // index of this viewer in viewers, used as the dump= URL parameter
+	name string // file name of the heap dump
+	d    *read.Dump
+
+	// mu serializes the pages of this dump.  The read.Dump methods
+	// share scratch space and compute indexes lazily.
+	mu sync.Mutex
+
+	// histogram by full type id
+	byType []bucket
+
+	// dump whose histogram this one is compared against, or nil
+	base *viewer
+
+	// referrers of recently viewed objects, and the order they were
+	// cached in, oldest first
+	refCache map[read.ObjId]cachedReferrers
+	refOrder []read.ObjId
+
+	// the strings page, computed the first time it is asked for
+	dupStrings *stringsInfo
+
+	total uint64 // bytes in all objects, for percentages
+
+	// unknown field kinds which have been logged
+	unknownKinds map[read.FieldKind]bool
+}
+
+// newViewer analyzes the heap dump d and returns a viewer for it.
+func newViewer(id int, name string, d *read.Dump) *viewer {
+	v := &viewer{id: id, name: name, d: d}
+	v.prepare()
+	return v
+}
+
+// A server serves pages for a set of viewers.  Each request is
+// dispatched to the viewer selected by its dump parameter.
+type server struct {
+	viewers []*viewer // all the loaded heap dumps, in command line order
+
+	mu     sync.Mutex
+	pins   map[string]map[pin]bool // pinned objects, by session cookie
+	status loadStatus
+
+	work chan bool // one entry for each page being computed
+}
+
+// A loadStatus says how far along loading the heap dumps is.  Until
+// they are loaded, only /healthz is served.
+type loadStatus struct {
+	Loaded   bool    `json:"loaded"`
+	Stage    string  `json:"stage"`
+	Progress float64 `json:"progress"` // fraction of the stages done
+}
+
+// setStatus records the stage of loading the server is at.
+func (s *server) setStatus(loaded bool, stage string, progress float64) {
+	s.mu.Lock()
+	s.status = loadStatus{loaded, stage, progress}
+	s.mu.Unlock()
+}
+
+// healthzHandler reports the load status as JSON.  It can be polled
+// to find out when the pages are ready.
+func (s *server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	st := s.status
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		log.Print(err)
+	}
+}
+
+// whenLoaded returns a handler that serves h once the dumps are
+// loaded.  Before then it serves only /healthz, and an error for
+// everything else.
+func (s *server) whenLoaded(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		loaded := s.status.Loaded
+		s.mu.Unlock()
+		if !loaded && r.URL.Path != "/healthz" {
+			http.Error(w, "still loading, see /healthz", http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// A pin identifies an object the user has set aside for review.
+type pin struct {
+	dump int
+	id   read.ObjId
+}
+
+// viewerFor returns the viewer selected by the dump parameter of
+// the request.  If there is no such viewer, it reports an error to
+// w and returns nil.
+func (s *server) viewerFor(w http.ResponseWriter, r *http.Request) *viewer {
+	v := r.URL.Query()["dump"]
+	if len(v) == 0 {
+		return s.viewers[0]
+	}
+	if len(v) != 1 {
+		http.Error(w, "too many dump parameters", 405)
+		return nil
+	}
+	id, err := strconv.ParseUint(v[0], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 405)
+		return nil
+	}
+	if id >= uint64(len(s.viewers)) {
+		http.Error(w, "dump not found", 405)
+		return nil
+	}
+	return s.viewers[id]
+}
+
+// handle returns an http handler which calls the viewer method h
+// on the viewer selected by the request.
+func (s *server) handle(h func(*viewer, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if v := s.viewerFor(w, r); v != nil {
+			s.work <- true
+			defer func() { <-s.work }()
+			v.mu.Lock()
+			defer v.mu.Unlock()
+			h(v, w, r)
+		}
+	}
+}
+
+// mux returns a ServeMux with all the hview pages registered.
+func (s *server) mux() *http.ServeMux {
+	m := http.NewServeMux()
+	m.HandleFunc("/healthz", s.healthzHandler)
+	m.HandleFunc("/", s.mainHandler)
+	m.HandleFunc("/obj", s.handle((*viewer).objHandler))
+	m.HandleFunc("/graph", s.graphHandler)
+	m.HandleFunc("/find", s.handle((*viewer).findHandler))
+	m.HandleFunc("/type", s.handle((*viewer).typeHandler))
+	m.HandleFunc("/histo", s.handle((*viewer).histoHandler))
+	m.HandleFunc("/globals", s.handle((*viewer).globalsHandler))
+	m.HandleFunc("/goroutines", s.handle((*viewer).goListHandler))
+	m.HandleFunc("/go", s.handle((*viewer).goHandler))
+	m.HandleFunc("/frame", s.handle((*viewer).frameHandler))
+	m.HandleFunc("/func", s.handle((*viewer).funcHandler))
+	m.HandleFunc("/others", s.handle((*viewer).othersHandler))
+	m.HandleFunc("/strings", s.handle((*viewer).stringsHandler))
+	m.HandleFunc("/conservative", s.handle((*viewer).conservativeHandler))
+	m.HandleFunc("/sizeclasses", s.handle((*viewer).sizeClassHandler))
+	m.HandleFunc("/hogs", s.handle((*viewer).hogsHandler))
+	m.HandleFunc("/dominatortree", s.handle((*viewer).domTreeHandler))
+	m.HandleFunc("/rootsplit", s.handle((*viewer).rootSplitHandler))
+	m.HandleFunc("/maps", s.handle((*viewer).mapsHandler))
+	m.HandleFunc("/symbols", s.handle((*viewer).symbolsHandler))
+	m.HandleFunc("/sharedarrays", s.handle((*viewer).sharedArraysHandler))
+	m.HandleFunc("/rawparams", s.handle((*viewer).rawParamsHandler))
+	m.HandleFunc("/pin", s.pinHandler)
+	m.HandleFunc("/pinned", s.pinnedHandler)
+	m.HandleFunc("/heapdump", heapdumpHandler)
+	m.HandleFunc("/debug/pprof/", httppprof.Index)
+	m.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	m.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	m.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	m.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+	return m
+}
 
 // link to type's page
-func typeLink(ft *read.FullType) string {
-	return fmt.Sprintf("<a href=\"type?id=%d\">%s</a>", ft.Id, ft.Name)
+func (v *viewer) typeLink(ft *read.FullType) string {
+	return fmt.Sprintf("<a href=\"type?dump=%d&id=%d\">%s</a>", v.id, ft.Id, html.EscapeString(ft.Name))
 }
 
-func objLink(x read.ObjId) string {
-	return fmt.Sprintf("<a href=obj?id=%d>object %x</a>", x, d.Addr(x))
+func (v *viewer) objLink(x read.ObjId) string {
+	return fmt.Sprintf("<a href=\"obj?dump=%d&id=%d\">object %x</a>", v.id, x, v.d.Addr(x))
 }
 
-// returns an html string representing the target of an Edge
-func edgeLink(e read.Edge) string {
-	s := objLink(e.To)
+// returns an html string representing the target of an Edge.  The +N
+// of an interior pointer links to the field it lands in.
+func (v *viewer) edgeLink(e read.Edge) string {
+	s := v.objLink(e.To)
 	if e.ToOffset != 0 {
-		s = fmt.Sprintf("%s+%d", s, e.ToOffset)
+		var off uint64
+		for _, f := range v.d.Ft(e.To).Fields {
+			if f.Offset > e.ToOffset {
+				break
+			}
+			off = f.Offset
+		}
+		s = fmt.Sprintf("%s<a href=\"obj?dump=%d&id=%d#%s\">+%d</a>", s, v.id, e.To, fieldAnchor(off), e.ToOffset)
 	}
 	return s
 }
 
 // returns an html string representing the source of an Edge
-func edgeSource(x read.ObjId, e read.Edge) string {
-	s := objLink(x)
+func (v *viewer) edgeSource(x read.ObjId, e read.Edge) string {
+	s := v.objLink(x)
 	if e.FieldName != "" {
-		s = fmt.Sprintf("%s.%s", s, e.FieldName)
+		s = fmt.Sprintf("%s.%s", s, html.EscapeString(e.FieldName))
 	}
 	if e.ToOffset != 0 {
 		s = fmt.Sprintf("%s+%d", s, e.ToOffset)
@@ -57,23 +266,112 @@ func edgeSource(x read.ObjId, e read.Edge) string {
 	return s
 }
 
-// the first d.PtrSize bytes of b contain a pointer.  Return html
-// to represent that pointer.
-func nonheapPtr(b []byte) string {
-	p := readPtr(b)
+// interiorNote describes where the data pointer of a string or slice,
+// the source of edge e, lands in its backing object when that isn't
+// the start: which element or byte, and how many there are from there
+// to the end of the object.
+func (v *viewer) interiorNote(e read.Edge) string {
+	if e.ToOffset == 0 {
+		return ""
+	}
+	d := v.d
+	rest := d.Size(e.To) - e.ToOffset
+	if ft := d.Ft(e.To); ft.Kind == read.TypeKindArray && ft.Typ != nil && ft.Typ.Size > 0 {
+		return fmt.Sprintf(" (from element %d of the array, %d elements to its end)", e.ToOffset/ft.Typ.Size, rest/ft.Typ.Size)
+	}
+	return fmt.Sprintf(" (from byte %d of the object, %d bytes to its end)", e.ToOffset, rest)
+}
+
+// nonheapPtr generates an html string describing the pointer at the
+// start of b, which doesn't point into the heap.  n is the length of
+// the string it is the data pointer of, or 0 if it isn't one.
+func (v *viewer) nonheapPtr(b []byte, n uint64) string {
+	p := v.d.ReadPtr(b)
 	if p == 0 {
 		return "nil"
 	} else {
-		// TODO: look up symbol in executable
-		return fmt.Sprintf("outsideheap_%x", p)
+		s := fmt.Sprintf("outsideheap_%x", p)
+		if name, off, ok := v.d.SymbolAt(p); ok {
+			s = html.EscapeString(name)
+			if off != 0 {
+				s += fmt.Sprintf("+%d", off)
+			}
+		}
+		if c, ok := v.rodataString(p, n); ok {
+			s += " " + html.EscapeString(c)
+		}
+		return s
+	}
+}
+
+// maxRodataPreview is the number of bytes of a string constant shown.
+const maxRodataPreview = 32
+
+// rodataString returns a quoted preview of the string constant at p in
+// the executable's read-only data.  n is the string's length, or 0 if
+// unknown, in which case the string runs to a NUL or nonprinting byte.
+// ok is false if p doesn't look like it points at a string.
+func (v *viewer) rodataString(p, n uint64) (s string, ok bool) {
+	m := n
+	if m == 0 || m > maxRodataPreview {
+		m = maxRodataPreview
+	}
+	b := v.d.Rodata(p, m)
+	if b == nil {
+		return "", false
+	}
+	if n == 0 {
+		for i, c := range b {
+			if c == 0 || c < ' ' || c >= 127 {
+				b = b[:i]
+				break
+			}
+		}
+		// A short run of printable bytes is likely not a string.
+		if len(b) < 4 {
+			return "", false
+		}
+	} else if !utf8.Valid(b) {
+		return "", false
 	}
+	s = strconv.Quote(string(b))
+	if n == 0 && uint64(len(b)) == m || n > m {
+		s += "..."
+	}
+	return s, true
 }
 
 // display field
 type Field struct {
-	Name  string
-	Typ   string
-	Value string
+	Name   template.HTML
+	Typ    string
+	Value  template.HTML
+	Anchor string // id of the row, for linking to an offset in an object
+}
+
+// fieldAnchor returns the id of the field row starting at offset off.
+func fieldAnchor(off uint64) string {
+	return fmt.Sprintf("off%d", off)
+}
+
+// fieldStart returns the offset of the field edge e comes from.  The
+// pointer of an interface is its second word.
+func fieldStart(d *read.Dump, e read.Edge) uint64 {
+	if e.Kind == read.FieldKindIface || e.Kind == read.FieldKindEface {
+		return e.FromOffset - d.PtrSize
+	}
+	return e.FromOffset
+}
+
+// formatFloat formats a float field's value f, whose representation
+// is the given bits of the given size.  By default it is shown to 6
+// significant digits.  With -fullfloats it is shown exactly, followed
+// by its bits, which tell apart NaNs and negative zero.
+func formatFloat(f float64, bits uint64, size int) string {
+	if !*fullFloats {
+		return strconv.FormatFloat(f, 'g', 6, size)
+	}
+	return fmt.Sprintf("%s (0x%0*x)", strconv.FormatFloat(f, 'g', -1, size), size/4, bits)
 }
 
 // rawBytes generates an html string representing the given raw bytes
@@ -91,16 +389,18 @@ func rawBytes(b []byte) string {
 }
 
 // getFields uses the data in b to fill in the values for the given field list.
-// edges is a list of known connecting out edges.
-func getFields(b []byte, fields []read.Field, edges []read.Edge) []Field {
+// edges is a list of known connecting out edges.  size is the size of
+// the whole object, of which b may be only a prefix.
+func (v *viewer) getFields(b []byte, size uint64, fields []read.Field, edges []read.Edge) []Field {
+	d := v.d
 	var r []Field
 	off := uint64(0)
-	for _, f := range fields {
+	for i, f := range fields {
 		if f.Offset < off {
 			log.Fatal("out of order fields")
 		}
 		if f.Offset > off {
-			r = append(r, Field{fmt.Sprintf("<font color=LightGray>pad %d</font>", f.Offset-off), "", ""})
+			r = append(r, Field{template.HTML(fmt.Sprintf("<font color=LightGray>pad %d</font>", f.Offset-off)), "", "", fieldAnchor(off)})
 			off = f.Offset
 		}
 		var value string
@@ -146,6 +446,28 @@ func getFields(b []byte, fields []read.Field, edges []read.Edge) []Field {
 			value = fmt.Sprintf("%d", int64(d.Order.Uint64(b[off:])))
 			typ = "int64"
 			off += 8
+		case read.FieldKindFloat32:
+			x := d.Order.Uint32(b[off:])
+			value = formatFloat(float64(math.Float32frombits(x)), uint64(x), 32)
+			typ = "float32"
+			off += 4
+		case read.FieldKindFloat64:
+			x := d.Order.Uint64(b[off:])
+			value = formatFloat(math.Float64frombits(x), x, 64)
+			typ = "float64"
+			off += 8
+		case read.FieldKindComplex64:
+			re := math.Float32frombits(d.Order.Uint32(b[off:]))
+			im := math.Float32frombits(d.Order.Uint32(b[off+4:]))
+			value = fmt.Sprintf("%v", complex(re, im))
+			typ = "complex64"
+			off += 8
+		case read.FieldKindComplex128:
+			re := math.Float64frombits(d.Order.Uint64(b[off:]))
+			im := math.Float64frombits(d.Order.Uint64(b[off+8:]))
+			value = fmt.Sprintf("%v", complex(re, im))
+			typ = "complex128"
+			off += 16
 		case read.FieldKindBytes8:
 			value = rawBytes(b[off : off+8])
 			typ = "raw bytes"
@@ -158,76 +480,116 @@ func getFields(b []byte, fields []read.Field, edges []read.Edge) []Field {
 			typ = "*" + f.BaseType
 			// TODO: get ptr base type somehow?  Also for slices,chans.
 			if len(edges) > 0 && edges[0].FromOffset == off {
-				value = edgeLink(edges[0])
+				value = v.edgeLink(edges[0])
 				edges = edges[1:]
 			} else {
-				value = nonheapPtr(b[off:])
+				value = v.nonheapPtr(b[off:], 0)
 			}
 			off += d.PtrSize
 		case read.FieldKindIface:
 			// TODO: the itab part?
 			typ = "interface{...}" + f.BaseType
 			if len(edges) > 0 && edges[0].FromOffset == off+d.PtrSize {
-				value = edgeLink(edges[0])
+				value = v.edgeLink(edges[0])
 				edges = edges[1:]
 			} else {
 				// TODO: use itab to decide whether this is a
 				// pointer or a scalar.
-				value = nonheapPtr(b[off+d.PtrSize:])
+				value = v.nonheapPtr(b[off+d.PtrSize:], 0)
 			}
 			off += 2 * d.PtrSize
 		case read.FieldKindEface:
 			// TODO: the type part
 			typ = "interface{}"
 			if len(edges) > 0 && edges[0].FromOffset == off+d.PtrSize {
-				value = edgeLink(edges[0])
+				value = v.edgeLink(edges[0])
 				edges = edges[1:]
 			} else {
 				// TODO: use type to decide whether this is a
 				// pointer or a scalar.
-				value = nonheapPtr(b[off+d.PtrSize:])
+				value = v.nonheapPtr(b[off+d.PtrSize:], 0)
 			}
 			off += 2 * d.PtrSize
 		case read.FieldKindString:
 			typ = "string"
+			note := ""
 			if len(edges) > 0 && edges[0].FromOffset == off {
-				value = edgeLink(edges[0])
+				value = v.edgeLink(edges[0])
+				note = v.interiorNote(edges[0])
 				edges = edges[1:]
 			} else {
-				value = nonheapPtr(b[off:])
+				value = v.nonheapPtr(b[off:], v.d.ReadPtr(b[off+d.PtrSize:]))
 			}
-			value = fmt.Sprintf("%s/%d", value, readPtr(b[off+d.PtrSize:]))
+			value = fmt.Sprintf("%s/%d%s", value, v.d.ReadPtr(b[off+d.PtrSize:]), note)
 			off += 2 * d.PtrSize
 		case read.FieldKindSlice:
 			typ = "[]" + f.BaseType
+			note := ""
 			if len(edges) > 0 && edges[0].FromOffset == off {
-				value = edgeLink(edges[0])
+				value = v.edgeLink(edges[0])
+				note = v.interiorNote(edges[0])
 				edges = edges[1:]
 			} else {
-				value = nonheapPtr(b[off:])
+				value = v.nonheapPtr(b[off:], 0)
 			}
-			value = fmt.Sprintf("%s/%d/%d", value, readPtr(b[off+d.PtrSize:]), readPtr(b[off+2*d.PtrSize:]))
+			value = fmt.Sprintf("%s/%d/%d%s", value, v.d.ReadPtr(b[off+d.PtrSize:]), v.d.ReadPtr(b[off+2*d.PtrSize:]), note)
 			off += 3 * d.PtrSize
 		case read.FieldKindBytesElided:
 			typ = "raw bytes"
-			value = fmt.Sprintf("... %d elided bytes ...", uint64(len(b))-off)
-			off = uint64(len(b))
+			value = fmt.Sprintf("... %d elided bytes ...", size-off)
+			off = size
+		default:
+			// Unknown field kind.  Show the bytes up to the next field.
+			if !v.unknownKinds[f.Kind] {
+				if v.unknownKinds == nil {
+					v.unknownKinds = map[read.FieldKind]bool{}
+				}
+				v.unknownKinds[f.Kind] = true
+				log.Printf("unknown field kind %d for field %s; further instances not reported", f.Kind, f.Name)
+			}
+			end := uint64(len(b))
+			if i+1 < len(fields) && fields[i+1].Offset < end {
+				end = fields[i+1].Offset
+			}
+			typ = fmt.Sprintf("unknown kind %d", f.Kind)
+			value = rawBytes(b[off:end])
+			off = end
 		}
-		r = append(r, Field{f.Name, typ, value})
+		r = append(r, Field{template.HTML(html.EscapeString(f.Name)), typ, template.HTML(value), fieldAnchor(f.Offset)})
 	}
-	if uint64(len(b)) > off {
-		r = append(r, Field{fmt.Sprintf("<font color=LightGray>sizeclass pad %d</font>", uint64(len(b))-off), "", ""})
+	if uint64(len(b)) < size && off < size {
+		r = append(r, Field{template.HTML(fmt.Sprintf("<font color=LightGray>... %d elided bytes ...</font>", size-off)), "", "", fieldAnchor(off)})
+	} else if uint64(len(b)) > off {
+		r = append(r, Field{template.HTML(fmt.Sprintf("<font color=LightGray>sizeclass pad %d</font>", uint64(len(b))-off)), "", "", fieldAnchor(off)})
+	}
+	return r
+}
+
+// htmls marks a list of strings built by the link functions as safe
+// HTML.
+func htmls(s []string) []template.HTML {
+	r := make([]template.HTML, len(s))
+	for i, x := range s {
+		r[i] = template.HTML(x)
 	}
 	return r
 }
 
 type objInfo struct {
-	Addr      uint64
-	Typ       string
-	Size      uint64
-	Fields    []Field
-	Referrers []string
-	Dominates uint64
+	Addr         uint64
+	Typ          template.HTML
+	Label        string // from the -labels file
+	Size         uint64
+	Conservative bool // fields are guesses
+	Outbound     string
+	Inbound      int
+	Fields       []Field
+	Referrers    []template.HTML
+	Dead         []template.HTML // referrers which are garbage
+	Dominates    uint64
+	DominatesPct string
+	Graph        string // url of neighborhood graph
+	Pin          string // url to pin this object
 }
 
 var objTemplate = template.Must(template.New("obj").Parse(`
@@ -247,8 +609,17 @@ border:1px solid grey;
 </head>
 <body>
 <tt>
-<h2>Object {{printf "%x" .Addr}} : {{.Typ}}</h2>
+<h2>Object {{printf "%x" .Addr}} : {{.Typ}}{{if .Label}} ({{.Label}}){{end}}</h2>
 <h3>{{.Size}} bytes</h3>
+{{if .Conservative}}
+<font color=Red>Conservatively scanned: the fields are guesses.  Any
+word that points into the heap is shown as a pointer, whether or not
+it is one.</font>
+<br>
+{{end}}
+<a href="{{.Pin}}">pin</a>
+<br>
+{{.Outbound}} outbound references, {{.Inbound}} inbound references
 <table>
 <tr>
 <td>Field</td>
@@ -256,7 +627,7 @@ border:1px solid grey;
 <td>Value</td>
 </tr>
 {{range .Fields}}
-<tr>
+<tr{{if .Anchor}} id="{{.Anchor}}"{{end}}>
 <td>{{.Name}}</td>
 <td>{{.Typ}}</td>
 <td>{{.Value}}</td>
@@ -268,67 +639,293 @@ border:1px solid grey;
 {{.}}
 <br>
 {{end}}
+{{if .Dead}}
+<h3>Garbage referrers</h3>
+<font color=Gray>Unreachable objects, which don't keep this one alive.</font>
+<br>
+{{range .Dead}}
+{{.}}
+<br>
+{{end}}
+{{end}}
 <h3>Heap dominated by this object</h3>
-{{.Dominates}} bytes
+{{.Dominates}} bytes ({{.DominatesPct}} of heap)
+<h3>Neighborhood</h3>
+<object data="{{.Graph}}" type="image/svg+xml"></object>
 </tt>
 </body>
 </html>
 `))
 
-func objHandler(w http.ResponseWriter, r *http.Request) {
+func (v *viewer) objHandler(w http.ResponseWriter, r *http.Request) {
+	d := v.d
 	q := r.URL.Query()
-	v := q["id"]
-	if len(v) != 1 {
+	s := q["id"]
+	if len(s) != 1 {
 		http.Error(w, "id parameter missing", 405)
 		return
 	}
-	id, err := strconv.ParseUint(v[0], 10, 64)
+	id, err := strconv.ParseUint(s[0], 10, 64)
 	if err != nil {
 		http.Error(w, err.Error(), 405)
 		return
 	}
 
-	if int(id) >= d.NumObjects() {
+	if id >= uint64(d.NumObjects()) {
 		http.Error(w, "object not found", 405)
 		return
 	}
 	x := read.ObjId(id)
 
-	fld := getFields(d.Contents(x), d.Ft(x).Fields, d.Edges(x))
-	if len(fld) > maxFields {
-		msg := fmt.Sprintf("<font color=Red>elided for display: %d fields</font>", len(fld)-(maxFields-1))
-		fld = fld[:maxFields-1]
-		fld = append(fld, Field{msg, "", ""})
+	n, fields, cut := truncateFields(d.Size(x), d.Ft(x).Fields, *maxBytes)
+	edges := d.EdgesPrefix(x, n)
+	outbound := fmt.Sprint(len(edges))
+	if cut {
+		// counting the rest would mean reading the whole object
+		outbound = "at least " + outbound
+	}
+	b := d.ContentsPrefix(x, n)
+	fld := v.getFields(b, d.Size(x), fields, edges)
+	conservative := d.Ft(x).Kind == read.TypeKindConservative
+	if conservative {
+		for i := range fld {
+			if fld[i].Typ != "" {
+				fld[i].Typ = "possible pointer"
+			}
+		}
+	}
+	if len(fld) > *maxFields {
+		msg := fmt.Sprintf("<font color=Red>elided for display: %d fields</font>", len(fld)-*maxFields)
+		fld = fld[:*maxFields]
+		fld = append(fld, Field{template.HTML(msg), "", "", ""})
+	}
+	if cut {
+		msg := fmt.Sprintf("<font color=Red>truncated for display: %d of %d bytes shown</font>", len(b), d.Size(x))
+		fld = append(fld, Field{template.HTML(msg), "", "", ""})
 	}
 
-	ref := getReferrers(x)
-	if len(ref) > maxFields {
-		msg := fmt.Sprintf("<font color=Red>elided for display: %d referrers</font>", len(ref)-(maxFields-1))
-		ref = ref[:maxFields-1]
+	ref, dead := v.getReferrers(x)
+	inbound := len(ref) + len(dead)
+	if len(ref) > *maxReferrers {
+		msg := fmt.Sprintf("<font color=Red>elided for display: %d referrers</font>", len(ref)-*maxReferrers)
+		ref = ref[:*maxReferrers:*maxReferrers] // don't overwrite the cache
 		ref = append(ref, msg)
 	}
+	if len(dead) > *maxReferrers {
+		msg := fmt.Sprintf("<font color=Red>elided for display: %d referrers</font>", len(dead)-*maxReferrers)
+		dead = dead[:*maxReferrers:*maxReferrers] // don't overwrite the cache
+		dead = append(dead, msg)
+	}
 
 	info := objInfo{
 		d.Addr(x),
-		typeLink(d.Ft(x)),
+		template.HTML(v.typeLink(d.Ft(x))),
+		d.Label(x),
 		d.Size(x),
+		conservative,
+		outbound,
+		inbound,
 		fld,
-		ref,
-		domsize[x],
+		htmls(ref),
+		htmls(dead),
+		d.RetainedSize(x),
+		v.percent(d.RetainedSize(x)),
+		fmt.Sprintf("graph?dump=%d&id=%d&depth=1", v.id, x),
+		fmt.Sprintf("pin?dump=%d&id=%d", v.id, x),
 	}
 	if err := objTemplate.Execute(w, info); err != nil {
 		log.Print(err)
 	}
 }
 
+// maxFieldWidth is the size of the widest field, a 64-bit slice.
+const maxFieldWidth = 24
+
+// truncateFields cuts an object of size bytes, and its fields, down
+// to about max bytes for display.  The cut is made at a field boundary
+// or in the padding after the last field.  It returns the number of
+// bytes to show and reports whether anything was cut.
+func truncateFields(size uint64, fields []read.Field, max uint64) (uint64, []read.Field, bool) {
+	if size <= max {
+		return size, fields, false
+	}
+	k := sort.Search(len(fields), func(i int) bool { return fields[i].Offset >= max })
+	end := max
+	if k > 0 && fields[k-1].Offset+maxFieldWidth > end {
+		end = fields[k-1].Offset + maxFieldWidth
+	}
+	if k < len(fields) && fields[k].Offset < end {
+		end = fields[k].Offset
+	}
+	if end >= size {
+		return size, fields, false
+	}
+	return end, fields[:k], true
+}
+
+// maxGraphNodes is the maximum number of objects drawn in a neighborhood graph.
+const maxGraphNodes = 64
+
+// graphHandler draws the neighborhood of an object: the objects it
+// refers to, up to depth edges away, and the objects that refer to it.
+// The drawing is done by graphviz, which must be installed.  The dump
+// isn't locked while graphviz runs, and graphviz is killed if it takes
+// longer than -dottimeout or the client goes away.
+func (s *server) graphHandler(w http.ResponseWriter, r *http.Request) {
+	v := s.viewerFor(w, r)
+	if v == nil {
+		return
+	}
+	s.work <- true
+	defer func() { <-s.work }()
+	src := v.graphSource(w, r)
+	if src == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), *dotTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "dot", "-Tsvg")
+	cmd.Stdin = bytes.NewReader(src)
+	svg, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		http.Error(w, fmt.Sprintf("graphviz took longer than %v", *dotTimeout), 503)
+		return
+	}
+	if err != nil {
+		http.Error(w, "running graphviz: "+err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(svg)
+}
+
+// graphSource returns the graphviz source for the graph graphHandler
+// draws.  If the request is bad, it writes the error to w and returns
+// nil.
+func (v *viewer) graphSource(w http.ResponseWriter, r *http.Request) []byte {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	d := v.d
+	q := r.URL.Query()
+	s := q["id"]
+	if len(s) != 1 {
+		http.Error(w, "id parameter missing", 405)
+		return nil
+	}
+	id, err := strconv.ParseUint(s[0], 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 405)
+		return nil
+	}
+	if id >= uint64(d.NumObjects()) {
+		http.Error(w, "object not found", 405)
+		return nil
+	}
+	x := read.ObjId(id)
+	depth := uint64(1)
+	if z := q.Get("depth"); z != "" {
+		depth, err = strconv.ParseUint(z, 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), 405)
+			return nil
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "digraph {\n")
+	seen := map[read.ObjId]bool{}
+	node := func(y read.ObjId) {
+		seen[y] = true
+		style := ""
+		if y == x {
+			style = " style=filled fillcolor=lightblue"
+		}
+		label := fmt.Sprintf("%s\n%d", d.Ft(y).Name, d.Size(y))
+		if l := d.Label(y); l != "" {
+			label += "\n" + l
+		}
+		fmt.Fprintf(&b, "  v%d [label=%s URL=\"obj?dump=%d&id=%d\" target=\"_top\"%s];\n",
+			y, read.DotQuote(label), v.id, y, style)
+	}
+	node(x)
+
+	// objects that x refers to, breadth first
+	cur := []read.ObjId{x}
+	for i := uint64(0); i < depth && len(cur) > 0; i++ {
+		var next []read.ObjId
+		for _, y := range cur {
+			for _, e := range d.Edges(y) {
+				if !seen[e.To] {
+					if len(seen) >= maxGraphNodes {
+						continue
+					}
+					node(e.To)
+					next = append(next, e.To)
+				}
+				if !seen[e.To] {
+					continue
+				}
+				fmt.Fprintf(&b, "  v%d -> v%d [taillabel=%s];\n", y, e.To, read.DotQuote(e.FieldName))
+			}
+		}
+		cur = next
+	}
+
+	// objects that refer to x
+	for _, y := range d.Referrers(x) {
+		if !seen[y] {
+			if len(seen) >= maxGraphNodes {
+				continue
+			}
+			node(y)
+		}
+		for _, e := range d.Edges(y) {
+			if e.To == x {
+				fmt.Fprintf(&b, "  v%d -> v%d [taillabel=%s];\n", y, x, read.DotQuote(e.FieldName))
+			}
+		}
+	}
+	fmt.Fprintf(&b, "}\n")
+	return b.Bytes()
+}
+
 type objEntry struct {
 	Id   read.ObjId
 	Addr uint64
 }
 type typeInfo struct {
+	Dump      int
+	Id        int
 	Name      string
 	Size      uint64
-	Instances []string
+	TypeAddr  string
+	TypeSize  string
+	Count     int
+	Total     uint64
+	TotalPct  string
+	Avg       uint64
+	Min       uint64
+	Max       uint64
+	Fields    []fieldRetained
+	Targets   []targetCount
+	Pointers  []pointerField
+	Instances []template.HTML
+}
+
+// A targetCount is the number of edges from instances of a type to
+// objects of another type.
+type targetCount struct {
+	Type  template.HTML
+	Count int
+}
+
+// fieldRetained records how much heap is retained, summed over all
+// instances of a type, by the objects that a field points to.
+type fieldRetained struct {
+	Name     string
+	Count    int // number of instances in which the field dominates its target
+	Retained uint64
 }
 
 var typeTemplate = template.Must(template.New("type").Parse(`
@@ -340,6 +937,73 @@ var typeTemplate = template.Must(template.New("type").Parse(`
 <tt>
 <h2>{{.Name}}</h2>
 <h3>Size {{.Size}}</h3>
+<table>
+{{if .TypeAddr}}
+<tr><td>Type address</td><td align="right">{{.TypeAddr}}</td></tr>
+<tr><td>Type size</td><td align="right">{{.TypeSize}}</td></tr>
+{{end}}
+<tr><td>Count</td><td align="right">{{.Count}}</td></tr>
+<tr><td>Total bytes</td><td align="right">{{.Total}}</td></tr>
+<tr><td>% of heap</td><td align="right">{{.TotalPct}}</td></tr>
+<tr><td>Average size</td><td align="right">{{.Avg}}</td></tr>
+<tr><td>Min size</td><td align="right">{{.Min}}</td></tr>
+<tr><td>Max size</td><td align="right">{{.Max}}</td></tr>
+</table>
+<h3>Retained by field</h3>
+<table>
+<tr>
+<td>Field</td>
+<td align="right">Count</td>
+<td align="right">Retained bytes</td>
+</tr>
+{{range .Fields}}
+<tr>
+<td>{{.Name}}</td>
+<td align="right">{{.Count}}</td>
+<td align="right">{{.Retained}}</td>
+</tr>
+{{end}}
+</table>
+<h3>Points to</h3>
+<table>
+<tr>
+<td>Type</td>
+<td align="right">Count</td>
+</tr>
+{{range .Targets}}
+<tr>
+<td>{{.Type}}</td>
+<td align="right">{{.Count}}</td>
+</tr>
+{{end}}
+</table>
+{{if .Pointers}}
+<h3>Pointer field targets</h3>
+<table>
+<tr>
+<td>Field</td>
+<td align="right">nil</td>
+<td align="right">Outside heap</td>
+<td>Points to</td>
+</tr>
+{{range .Pointers}}
+<tr>
+<td>{{.Name}}</td>
+<td align="right">{{.Nil}}</td>
+<td align="right">{{.NonHeap}}</td>
+<td>{{range .Targets}}{{.Type}} {{.Count}}<br>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+<h3>Find instances</h3>
+<form action="find">
+<input type="hidden" name="dump" value="{{.Dump}}">
+<input type="hidden" name="type" value="{{.Id}}">
+field <input type="text" name="field">
+value <input type="text" name="value">
+<input type="submit" value="Find">
+</form>
 <h3>Instances</h3>
 <table>
 {{range .Instances}}
@@ -351,7 +1015,8 @@ var typeTemplate = template.Must(template.New("type").Parse(`
 </html>
 `))
 
-func typeHandler(w http.ResponseWriter, r *http.Request) {
+func (v *viewer) typeHandler(w http.ResponseWriter, r *http.Request) {
+	d := v.d
 	q := r.URL.Query()
 	s := q["id"]
 	if len(s) != 1 {
@@ -371,53 +1036,384 @@ func typeHandler(w http.ResponseWriter, r *http.Request) {
 
 	ft := d.FTList[id]
 	var info typeInfo
+	info.Dump = v.id
+	info.Id = ft.Id
 	info.Name = ft.Name
 	info.Size = ft.Size
-	for _, x := range byType[ft.Id].objects {
-		info.Instances = append(info.Instances, objLink(x))
-	}
+	info.TypeAddr, info.TypeSize = typeAddrSize(ft)
+	v.instanceInfo(ft, &info)
+	info.TotalPct = v.percent(info.Total)
 	if err := typeTemplate.Execute(w, info); err != nil {
 		log.Print(err)
 	}
 }
 
-type hentry struct {
-	Name  string
-	Count int
-	Bytes uint64
+// instanceInfo fills in the parts of info which come from the instances
+// of ft, in one pass over them: their sizes, the heap retained through
+// each field, the types of the objects they point to, and the values of
+// their pointer fields.  A field retains its target's dominated heap if
+// the object containing the field is the target's immediate dominator.
+func (v *viewer) instanceInfo(ft *read.FullType, info *typeInfo) {
+	d := v.d
+	retained := map[string]*fieldRetained{}
+	seen := map[read.ObjId]bool{} // targets already attributed to a field
+	targets := map[int]int{}
+
+	// Only the object up to the end of the last pointer field is read.
+	ptrs := pointerFields(ft)
+	var end uint64
+	info.Pointers = make([]pointerField, len(ptrs))
+	ptrTargets := make([]map[int]int, len(ptrs))
+	for i, f := range ptrs {
+		info.Pointers[i].Name = f.Name
+		if f.Name == "" {
+			info.Pointers[i].Name = fmt.Sprintf("offset %d", f.Offset)
+		}
+		ptrTargets[i] = map[int]int{}
+		end = f.Offset + d.PtrSize
+	}
+
+	for _, x := range v.byType[ft.Id].objects {
+		size := d.Size(x)
+		if info.Count == 0 || size < info.Min {
+			info.Min = size
+		}
+		if size > info.Max {
+			info.Max = size
+		}
+		info.Count++
+		info.Total += size
+		info.Instances = append(info.Instances, template.HTML(v.objLink(x)))
+
+		for _, e := range d.Edges(x) {
+			targets[d.Ft(e.To).Id]++
+			if d.Idom(e.To) != x || seen[e.To] {
+				continue
+			}
+			// Don't count a target twice if x points to it
+			// from more than one field.
+			seen[e.To] = true
+			name := e.FieldName
+			if name == "" {
+				name = fmt.Sprintf("offset %d", e.FromOffset)
+			}
+			f := retained[name]
+			if f == nil {
+				f = &fieldRetained{Name: name}
+				retained[name] = f
+			}
+			f.Count++
+			f.Retained += d.RetainedSize(e.To)
+		}
+
+		if len(ptrs) == 0 {
+			continue
+		}
+		b := d.ContentsPrefix(x, end) // after Edges, which reuses its buffer
+		for i, f := range ptrs {
+			p := d.ReadPtr(b[f.Offset:])
+			if p == 0 {
+				info.Pointers[i].Nil++
+				continue
+			}
+			y := d.FindObj(p)
+			if y == read.ObjNil {
+				info.Pointers[i].NonHeap++
+				continue
+			}
+			ptrTargets[i][d.Ft(y).Id]++
+		}
+	}
+	if info.Count > 0 {
+		info.Avg = info.Total / uint64(info.Count)
+	}
+
+	for _, f := range retained {
+		info.Fields = append(info.Fields, *f)
+	}
+	sort.Sort(byRetained(info.Fields))
+	info.Targets = v.typeCounts(targets)
+	for i := range info.Pointers {
+		info.Pointers[i].Targets = v.typeCounts(ptrTargets[i])
+	}
 }
 
-var histoTemplate = template.Must(template.New("histo").Parse(`
-<html>
-<head>
-<style>
-table
-{
-border-collapse:collapse;
+// typeCounts turns counts indexed by full type id into a list, most
+// common first.
+func (v *viewer) typeCounts(n map[int]int) []targetCount {
+	var ids []int
+	for id := range n {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var r []targetCount
+	for _, id := range ids {
+		r = append(r, targetCount{template.HTML(v.typeLink(v.d.FTList[id])), n[id]})
+	}
+	sort.Stable(byTargetCount(r))
+	return r
 }
-table, td, th
-{
-border:1px solid grey;
+
+// maxPointerFields is the number of pointer fields whose targets are
+// shown on a type page.  Arrays of pointers have one per element.
+const maxPointerFields = 64
+
+// A pointerField is the distribution of what a pointer field points to
+// over all the instances of a type.
+type pointerField struct {
+	Name    string
+	Nil     int
+	NonHeap int // pointers outside the heap
+	Targets []targetCount
 }
-</style>
-<title>Type histogram</title>
-</head>
-<body>
-<tt>
-<table>
-<col align="left">
-<col align="right">
-<col align="right">
-<tr>
+
+// pointerFields returns the pointer, string and slice fields of type
+// ft, up to maxPointerFields of them, in offset order.
+func pointerFields(ft *read.FullType) []read.Field {
+	var fields []read.Field
+	for _, f := range ft.Fields {
+		switch f.Kind {
+		case read.FieldKindPtr, read.FieldKindString, read.FieldKindSlice:
+			if len(fields) < maxPointerFields {
+				fields = append(fields, f)
+			}
+		}
+	}
+	return fields
+}
+
+type byTargetCount []targetCount
+
+func (a byTargetCount) Len() int           { return len(a) }
+func (a byTargetCount) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTargetCount) Less(i, j int) bool { return a[i].Count > a[j].Count }
+
+type byRetained []fieldRetained
+
+func (a byRetained) Len() int      { return len(a) }
+func (a byRetained) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byRetained) Less(i, j int) bool {
+	if a[i].Retained != a[j].Retained {
+		return a[i].Retained > a[j].Retained
+	}
+	return a[i].Name < a[j].Name
+}
+
+type findInfo struct {
+	Type    template.HTML
+	Field   string
+	Value   string
+	Matches []template.HTML
+}
+
+var findTemplate = template.Must(template.New("find").Parse(`
+<html>
+<head>
+<title>Find</title>
+</head>
+<body>
+<tt>
+<h2>Instances of {{.Type}} with {{.Field}} = {{.Value}}</h2>
+<table>
+{{range .Matches}}
+<tr><td>{{.}}</td></tr>
+{{end}}
+</table>
+</tt>
+</body>
+</html>
+`))
+
+// findHandler lists the instances of a type whose named field has
+// a given value.
+func (v *viewer) findHandler(w http.ResponseWriter, r *http.Request) {
+	d := v.d
+	q := r.URL.Query()
+	id, err := strconv.ParseUint(q.Get("type"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 405)
+		return
+	}
+	if id >= uint64(len(d.FTList)) {
+		http.Error(w, "can't find type", 405)
+		return
+	}
+	ft := d.FTList[id]
+	name := q.Get("field")
+	var f read.Field
+	found := false
+	for _, g := range ft.Fields {
+		if g.Name == name {
+			f = g
+			found = true
+			break
+		}
+	}
+	if !found {
+		http.Error(w, "can't find field "+name, 405)
+		return
+	}
+	value := q.Get("value")
+	match, err := v.fieldMatcher(f, value)
+	if err != nil {
+		http.Error(w, err.Error(), 405)
+		return
+	}
+
+	info := findInfo{template.HTML(v.typeLink(ft)), name, value, nil}
+	for _, x := range v.byType[ft.Id].objects {
+		if !match(d.Contents(x)[f.Offset:]) {
+			continue
+		}
+		if len(info.Matches) == *maxFields {
+			info.Matches = append(info.Matches, template.HTML("<font color=Red>more matches elided</font>"))
+			break
+		}
+		info.Matches = append(info.Matches, template.HTML(v.objLink(x)))
+	}
+	if err := findTemplate.Execute(w, info); err != nil {
+		log.Print(err)
+	}
+}
+
+// fieldMatcher returns a function which reports whether the field f,
+// whose contents start at the beginning of its argument, has the given
+// value.  Pointers and the data words of strings, slices, and
+// interfaces are matched against an address.
+func (v *viewer) fieldMatcher(f read.Field, value string) (func([]byte) bool, error) {
+	d := v.d
+	switch f.Kind {
+	case read.FieldKindBool:
+		x, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(b []byte) bool { return (b[0] != 0) == x }, nil
+	case read.FieldKindUInt8, read.FieldKindUInt16, read.FieldKindUInt32, read.FieldKindUInt64:
+		x, err := strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func(b []byte) bool { return v.readUint(b, f.Kind) == x }, nil
+	case read.FieldKindSInt8, read.FieldKindSInt16, read.FieldKindSInt32, read.FieldKindSInt64:
+		x, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func(b []byte) bool { return v.readInt(b, f.Kind) == x }, nil
+	case read.FieldKindFloat32:
+		x, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return nil, err
+		}
+		return func(b []byte) bool { return math.Float32frombits(d.Order.Uint32(b)) == float32(x) }, nil
+	case read.FieldKindFloat64:
+		x, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func(b []byte) bool { return math.Float64frombits(d.Order.Uint64(b)) == x }, nil
+	case read.FieldKindPtr, read.FieldKindString, read.FieldKindSlice:
+		x, err := strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func(b []byte) bool { return v.d.ReadPtr(b) == x }, nil
+	case read.FieldKindIface, read.FieldKindEface:
+		x, err := strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func(b []byte) bool { return v.d.ReadPtr(b[d.PtrSize:]) == x }, nil
+	}
+	return nil, fmt.Errorf("can't search fields of kind %d", f.Kind)
+}
+
+// readUint returns the unsigned integer of the given kind at the start of b.
+func (v *viewer) readUint(b []byte, k read.FieldKind) uint64 {
+	switch k {
+	case read.FieldKindUInt8:
+		return uint64(b[0])
+	case read.FieldKindUInt16:
+		return uint64(v.d.Order.Uint16(b))
+	case read.FieldKindUInt32:
+		return uint64(v.d.Order.Uint32(b))
+	}
+	return v.d.Order.Uint64(b)
+}
+
+// readInt returns the signed integer of the given kind at the start of b.
+func (v *viewer) readInt(b []byte, k read.FieldKind) int64 {
+	switch k {
+	case read.FieldKindSInt8:
+		return int64(int8(b[0]))
+	case read.FieldKindSInt16:
+		return int64(int16(v.d.Order.Uint16(b)))
+	case read.FieldKindSInt32:
+		return int64(int32(v.d.Order.Uint32(b)))
+	}
+	return int64(v.d.Order.Uint64(b))
+}
+
+type hentry struct {
+	Name     template.HTML
+	TypeAddr string
+	TypeSize string
+	Count    int
+	Bytes    uint64
+	Percent  string
+	Delta    string // change in bytes from the base dump
+}
+
+// histoInfo is the type histogram, along with the name of the dump
+// its rows are compared against, if any.
+type histoInfo struct {
+	Base string
+	Rows []hentry
+}
+
+var histoTemplate = template.Must(template.New("histo").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Type histogram</title>
+</head>
+<body>
+<tt>
+<table>
+<col align="left">
+<col align="right">
+<col align="right">
+<col align="right">
+<col align="right">
+<col align="right">
+<tr>
 <td>Type</td>
+<td align="right">Type address</td>
+<td align="right">Type size</td>
 <td align="right">Count</td>
 <td align="right">Bytes</td>
+<td align="right">% of heap</td>
+{{if .Base}}<td align="right">Change from {{.Base}}</td>{{end}}
 </tr>
-{{range .}}
+{{range .Rows}}
 <tr>
 <td>{{.Name}}</td>
+<td align="right">{{.TypeAddr}}</td>
+<td align="right">{{.TypeSize}}</td>
 <td align="right">{{.Count}}</td>
 <td align="right">{{.Bytes}}</td>
+<td align="right">{{.Percent}}</td>
+{{if $.Base}}<td align="right">{{.Delta}}</td>{{end}}
 </tr>
 {{end}}
 </table>
@@ -426,18 +1422,99 @@ border:1px solid grey;
 </html>
 `))
 
-func histoHandler(w http.ResponseWriter, r *http.Request) {
-	// build sorted list of types
+func (v *viewer) histoHandler(w http.ResponseWriter, r *http.Request) {
+	var base string
+	if v.base != nil {
+		base = v.base.name
+	}
+	if err := histoTemplate.Execute(w, histoInfo{base, v.histogram()}); err != nil {
+		log.Print(err)
+	}
+}
+
+// histogram returns the rows of the type histogram, largest first.
+func (v *viewer) histogram() []hentry {
 	var s []hentry
-	for id, b := range byType {
-		ft := d.FTList[id]
-		s = append(s, hentry{typeLink(ft), len(b.objects), b.bytes})
+	if v.base == nil {
+		for id, b := range v.byType {
+			ft := v.d.FTList[id]
+			addr, size := typeAddrSize(ft)
+			s = append(s, hentry{template.HTML(v.typeLink(ft)), addr, size, len(b.objects), b.bytes, v.percent(b.bytes), ""})
+		}
+	} else {
+		// Compare by base type name, since full types don't
+		// correspond across dumps.  Types only in the base link
+		// to it.
+		var names []string
+		seen := map[string]bool{}
+		for _, fts := range [][]*read.FullType{v.d.FTList, v.base.d.FTList} {
+			for _, ft := range fts {
+				if name := baseName(ft); !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+		for _, name := range names {
+			b, old := v.byName(name), v.base.byName(name)
+			dump := v // the dump the row links to
+			if b.ft == nil {
+				if old.ft == nil {
+					continue
+				}
+				dump, b.ft = v.base, old.ft
+			}
+			addr, size := typeAddrSize(b.ft)
+			link := fmt.Sprintf("<a href=\"type?dump=%d&id=%d\">%s</a>", dump.id, b.ft.Id, html.EscapeString(name))
+			delta := deltaString(int64(b.bytes) - int64(old.bytes))
+			s = append(s, hentry{template.HTML(link), addr, size, b.count, b.bytes, v.percent(b.bytes), delta})
+		}
 	}
 	sort.Sort(ByBytes(s))
+	return s
+}
 
-	if err := histoTemplate.Execute(w, s); err != nil {
-		log.Print(err)
+// deltaString formats a change of n bytes with an arrow showing its
+// direction, like ▲+1.2MB or ▼-300KB.  It returns "" if n is 0.
+func deltaString(n int64) string {
+	switch {
+	case n > 0:
+		return "▲+" + byteString(uint64(n))
+	case n < 0:
+		return "▼-" + byteString(uint64(-n))
+	}
+	return ""
+}
+
+// byteString formats n bytes in the largest unit it has at least one of.
+func byteString(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.0fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// percent formats n as a percentage of the bytes in the heap.
+func (v *viewer) percent(n uint64) string {
+	if v.total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(v.total))
+}
+
+// typeAddrSize returns the address and size of the runtime type
+// underlying ft, which tell apart types with the same name.  Both are
+// empty if ft has no runtime type.
+func typeAddrSize(ft *read.FullType) (addr, size string) {
+	if ft.Typ == nil {
+		return "", ""
 	}
+	return fmt.Sprintf("%x", ft.Typ.Addr), fmt.Sprint(ft.Typ.Size)
 }
 
 type ByBytes []hentry
@@ -447,9 +1524,30 @@ func (a ByBytes) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByBytes) Less(i, j int) bool { return a[i].Bytes > a[j].Bytes }
 
 type mainInfo struct {
-	HeapSize   uint64
-	HeapUsed   uint64
-	NumObjects int
+	Dump           int
+	Name           string
+	Time           string
+	Truncated      bool
+	Dwarf          read.DwarfStats
+	BuildID        string
+	Dumps          []dumpEntry
+	HeapSize       uint64
+	HeapUsed       uint64
+	NumObjects     int
+	ObjectBytes    uint64
+	NumLiveObjects int
+	LiveBytes      uint64
+	NumPoolObjects int
+	PoolBytes      uint64
+	NumGaps        int
+	FreeBytes      uint64
+	LargestGap     uint64
+}
+
+type dumpEntry struct {
+	Id   int
+	Name string
+	Time string
 }
 
 var mainTemplate = template.Must(template.New("histo").Parse(`
@@ -461,24 +1559,105 @@ var mainTemplate = template.Must(template.New("histo").Parse(`
 <tt>
 
 <h2>Heap dump viewer</h2>
+<h3>{{.Name}}</h3>
+Taken at {{.Time}}
+<br>
+{{if .Truncated}}
+<font color=Red>This dump is truncated.  It ends before its EOF record.</font>
+<br>
+{{end}}
+{{if .BuildID}}
+Executable build ID: {{.BuildID}}
+<br>
+{{end}}
+{{with .Dwarf}}{{if .Types}}
+Types named from DWARF: {{.Named}} of {{.Types}}
+({{.Missing}} not found, {{.Inconsistent}} inconsistent, {{.Skipped}} skipped)
+<br>
+{{end}}{{end}}
+{{if gt (len .Dumps) 1}}
+Dumps:
+{{range .Dumps}}
+<a href="/?dump={{.Id}}">{{.Name}}</a> ({{.Time}})
+{{end}}
+<br>
+{{end}}
 <br>
 Heap size: {{.HeapSize}} bytes
 <br>
-Heap live: {{.HeapUsed}} bytes
+Heap live (from memstats): {{.HeapUsed}} bytes
+<br>
+Heap objects: {{.NumObjects}} ({{.ObjectBytes}} bytes)
 <br>
-Heap objects: {{.NumObjects}}
+Reachable objects: {{.NumLiveObjects}} ({{.LiveBytes}} bytes)
 <br>
-<a href="histo">Type Histogram</a>
-<a href="globals">Globals</a>
-<a href="goroutines">Goroutines</a>
-<a href="others">Miscellaneous Roots</a>
+Cached in sync.Pools: {{.NumPoolObjects}} objects ({{.PoolBytes}} bytes)
+<br>
+Free space between objects: {{.FreeBytes}} bytes in {{.NumGaps}} gaps, largest {{.LargestGap}} bytes
+<br>
+<a href="histo?dump={{.Dump}}">Type Histogram</a>
+<a href="globals?dump={{.Dump}}">Globals</a>
+<a href="goroutines?dump={{.Dump}}">Goroutines</a>
+<a href="others?dump={{.Dump}}">Miscellaneous Roots</a>
+<a href="strings?dump={{.Dump}}">Duplicate Strings</a>
+<a href="conservative?dump={{.Dump}}">Conservative Edges</a>
+<a href="sizeclasses?dump={{.Dump}}">Size Classes</a>
+<a href="hogs?dump={{.Dump}}">Memory Hogs</a>
+<a href="dominatortree?dump={{.Dump}}">Dominator Tree</a>
+<a href="rootsplit?dump={{.Dump}}">Retained by Root Kind</a>
+<a href="maps?dump={{.Dump}}">Large Maps</a>
+<a href="sharedarrays?dump={{.Dump}}">Shared Backing Arrays</a>
+<a href="symbols?dump={{.Dump}}">Symbols</a>
+<a href="rawparams?dump={{.Dump}}">Dump Parameters</a>
+<a href="pinned">Pinned Objects</a>
 </tt>
 </body>
 </html>
 `))
 
-func mainHandler(w http.ResponseWriter, r *http.Request) {
-	i := mainInfo{d.HeapEnd - d.HeapStart, d.Memstats.Alloc, d.NumObjects()}
+func (s *server) mainHandler(w http.ResponseWriter, r *http.Request) {
+	v := s.viewerFor(w, r)
+	if v == nil {
+		return
+	}
+	s.work <- true
+	defer func() { <-s.work }()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	d := v.d
+	var dumps []dumpEntry
+	for _, u := range s.viewers {
+		dumps = append(dumps, dumpEntry{u.id, u.name, u.d.Time.Format(time.Stamp)})
+	}
+	i := mainInfo{
+		v.id,
+		v.name,
+		d.Time.Format(time.RFC1123),
+		d.Truncated,
+		d.Dwarf,
+		d.BuildID,
+		dumps,
+		d.HeapEnd - d.HeapStart,
+		d.Memstats.Alloc,
+		d.NumObjects(),
+		d.TotalBytes(),
+		d.NumLiveObjects(),
+		d.LiveBytes(),
+		0,
+		0,
+		0,
+		0,
+		0,
+	}
+	i.NumPoolObjects, i.PoolBytes = d.PoolRetained()
+	for _, g := range d.HeapGaps() {
+		n := g.End - g.Start
+		i.NumGaps++
+		i.FreeBytes += n
+		if n > i.LargestGap {
+			i.LargestGap = n
+		}
+	}
 	if err := mainTemplate.Execute(w, i); err != nil {
 		log.Print(err)
 	}
@@ -507,12 +1686,14 @@ border:1px solid grey;
 <td>Name</td>
 <td>Type</td>
 <td>Value</td>
+<td align="right">Retained bytes</td>
 </tr>
 {{range .}}
 <tr>
 <td>{{.Name}}</td>
 <td>{{.Typ}}</td>
 <td>{{.Value}}</td>
+<td align="right">{{.Retained}}</td>
 </tr>
 {{end}}
 </table>
@@ -521,16 +1702,48 @@ border:1px solid grey;
 </html>
 `))
 
-func globalsHandler(w http.ResponseWriter, r *http.Request) {
-	var f []Field
+// A globalEntry is a row of the globals or others page.  Retained is
+// the heap dominated by the objects the root points to.
+type globalEntry struct {
+	Field
+	Retained uint64
+}
+
+func (v *viewer) globalsHandler(w http.ResponseWriter, r *http.Request) {
+	d := v.d
+	var g []globalEntry
 	for _, x := range []*read.Data{d.Data, d.Bss} {
-		f = append(f, getFields(x.Data, x.Fields, x.Edges)...)
+		// sum the retained sizes of each global's distinct targets,
+		// by the anchor of the global's row, which is its offset
+		retained := map[string]uint64{}
+		seen := map[read.ObjId]bool{}
+		for _, e := range x.Edges {
+			if !seen[e.To] {
+				seen[e.To] = true
+				retained[fieldAnchor(fieldStart(d, e))] += d.RetainedSize(e.To)
+			}
+		}
+		for _, f := range v.getFields(x.Data, uint64(len(x.Data)), x.Fields, x.Edges) {
+			g = append(g, globalEntry{f, retained[f.Anchor]})
+		}
 	}
-	if err := globalsTemplate.Execute(w, f); err != nil {
+	sort.Stable(byGlobalRetained(g))
+	if len(g) > *maxGlobals {
+		msg := fmt.Sprintf("<font color=Red>elided for display: %d globals</font>", len(g)-*maxGlobals)
+		g = g[:*maxGlobals]
+		g = append(g, globalEntry{Field{template.HTML(msg), "", "", ""}, 0})
+	}
+	if err := globalsTemplate.Execute(w, g); err != nil {
 		log.Print(err)
 	}
 }
 
+type byGlobalRetained []globalEntry
+
+func (a byGlobalRetained) Len() int           { return len(a) }
+func (a byGlobalRetained) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byGlobalRetained) Less(i, j int) bool { return a[i].Retained > a[j].Retained }
+
 var othersTemplate = template.Must(template.New("others").Parse(`
 <html>
 <head>
@@ -554,12 +1767,14 @@ border:1px solid grey;
 <td>Name</td>
 <td>Type</td>
 <td>Value</td>
+<td align="right">Retained bytes</td>
 </tr>
 {{range .}}
 <tr>
 <td>{{.Name}}</td>
 <td>{{.Typ}}</td>
 <td>{{.Value}}</td>
+<td align="right">{{.Retained}}</td>
 </tr>
 {{end}}
 </table>
@@ -568,24 +1783,1371 @@ border:1px solid grey;
 </html>
 `))
 
-func othersHandler(w http.ResponseWriter, r *http.Request) {
-	var f []Field
-	for _, x := range d.Otherroots {
+func (v *viewer) othersHandler(w http.ResponseWriter, r *http.Request) {
+	var g []globalEntry
+	for _, x := range v.d.Otherroots {
 		for _, e := range x.Edges {
-			f = append(f, Field{x.Description, "unknown", edgeLink(e)})
+			f := Field{template.HTML(html.EscapeString(x.Description)), "unknown", template.HTML(v.edgeLink(e)), ""}
+			g = append(g, globalEntry{f, v.d.RetainedSize(e.To)})
+		}
+	}
+	sort.Stable(byGlobalRetained(g))
+	if err := othersTemplate.Execute(w, g); err != nil {
+		log.Print(err)
+	}
+}
+
+// maxStrings is the number of duplicated strings shown on the strings page.
+const maxStrings = 100
+
+// maxStringPreview is the number of bytes of a string shown on the strings page.
+const maxStringPreview = 64
+
+// A stringRef is a reference from a string header to its contents.
+type stringRef struct {
+	obj read.ObjId // object holding the string contents
+	off uint64     // offset of the contents in obj
+	len uint64     // length of the string
+}
+
+// stringRefs appends to refs the references to string contents made by
+// the string fields among edges.  data holds the contents of the
+// object or root the edges come from.
+func (v *viewer) stringRefs(refs []stringRef, data []byte, edges []read.Edge) []stringRef {
+	for _, e := range edges {
+		if e.Kind != read.FieldKindString {
+			continue
+		}
+		n := v.d.ReadPtr(data[e.FromOffset+v.d.PtrSize:])
+		if n > 0 && e.ToOffset+n <= v.d.Size(e.To) {
+			refs = append(refs, stringRef{e.To, e.ToOffset, n})
+		}
+	}
+	return refs
+}
+
+type stringEntry struct {
+	Value  string
+	Len    uint64
+	Count  int    // number of distinct copies of the string
+	Wasted uint64 // bytes that would be saved by interning
+}
+
+type stringsInfo struct {
+	Headers     int
+	TotalBytes  uint64
+	UniqueBytes uint64
+	Savings     uint64
+	Strings     []stringEntry
+}
+
+var stringsTemplate = template.Must(template.New("strings").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Duplicate strings</title>
+</head>
+<body>
+<tt>
+<h2>Duplicate strings</h2>
+String headers: {{.Headers}}
+<br>
+String bytes: {{.TotalBytes}}
+<br>
+Unique string bytes: {{.UniqueBytes}}
+<br>
+Savings from interning: {{.Savings}} bytes
+<br>
+<table>
+<tr>
+<td>Value</td>
+<td align="right">Length</td>
+<td align="right">Copies</td>
+<td align="right">Wasted bytes</td>
+</tr>
+{{range .Strings}}
+<tr>
+<td>{{.Value}}</td>
+<td align="right">{{.Len}}</td>
+<td align="right">{{.Count}}</td>
+<td align="right">{{.Wasted}}</td>
+</tr>
+{{end}}
+</table>
+</tt>
+</body>
+</html>
+`))
+
+func (v *viewer) stringsHandler(w http.ResponseWriter, r *http.Request) {
+	if v.dupStrings == nil {
+		v.dupStrings = v.duplicateStrings()
+	}
+	if err := stringsTemplate.Execute(w, v.dupStrings); err != nil {
+		log.Print(err)
+	}
+}
+
+// duplicateStrings finds the strings in the dump with more than one
+// copy.  It scans the whole heap, so stringsHandler saves its result.
+func (v *viewer) duplicateStrings() *stringsInfo {
+	d := v.d
+
+	// find all the string headers in the heap and in the roots
+	var refs []stringRef
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		edges := d.Edges(x) // before Contents, which it would overwrite
+		refs = v.stringRefs(refs, d.Contents(x), edges)
+	}
+	for _, x := range []*read.Data{d.Data, d.Bss} {
+		refs = v.stringRefs(refs, x.Data, x.Edges)
+	}
+	for _, f := range d.Frames {
+		refs = v.stringRefs(refs, f.Data, f.Edges)
+	}
+
+	// Group copies of strings by value.  Several headers may
+	// refer to the same copy; those don't count as duplicates.
+	copies := map[stringRef]struct{}{}
+	values := map[string]*stringEntry{}
+	var info stringsInfo
+	info.Headers = len(refs)
+	for _, ref := range refs {
+		if _, ok := copies[ref]; ok {
+			continue
+		}
+		copies[ref] = struct{}{}
+		b := d.Contents(ref.obj)[ref.off : ref.off+ref.len]
+		info.TotalBytes += ref.len
+		e := values[string(b)]
+		if e == nil {
+			e = &stringEntry{Len: ref.len}
+			values[string(b)] = e
+			info.UniqueBytes += ref.len
+		} else {
+			e.Wasted += ref.len
+		}
+		e.Count++
+	}
+	info.Savings = info.TotalBytes - info.UniqueBytes
+
+	for s, e := range values {
+		if e.Count == 1 {
+			continue
+		}
+		if len(s) > maxStringPreview {
+			s = s[:maxStringPreview] + "..."
+		}
+		e.Value = strconv.Quote(s)
+		info.Strings = append(info.Strings, *e)
+	}
+	sort.Sort(byWasted(info.Strings))
+	if len(info.Strings) > maxStrings {
+		info.Strings = info.Strings[:maxStrings]
+	}
+	return &info
+}
+
+type byWasted []stringEntry
+
+func (a byWasted) Len() int      { return len(a) }
+func (a byWasted) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byWasted) Less(i, j int) bool {
+	if a[i].Wasted != a[j].Wasted {
+		return a[i].Wasted > a[j].Wasted
+	}
+	return a[i].Value < a[j].Value
+}
+
+// maxConservative is the number of edges shown on the conservative edges page.
+const maxConservative = 100
+
+type consPage struct {
+	Resolved int    // words that point into an object
+	Words    int    // all words of conservatively scanned objects
+	Percent  string // Resolved as a percentage of Words
+	Edges    []consEntry
+}
+
+type consEntry struct {
+	Source   template.HTML
+	Target   template.HTML
+	Retained uint64
+}
+
+var conservativeTemplate = template.Must(template.New("conservative").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Conservative edges</title>
+</head>
+<body>
+<tt>
+<h2>Conservative edges</h2>
+{{.Resolved}} of {{.Words}} words in conservatively scanned objects
+({{.Percent}}) resolve to objects.
+<br>
+<br>
+Edges from conservatively scanned objects which are the only thing
+keeping their target alive.  These may come from non-pointer words
+which happen to look like heap addresses.
+<table>
+<tr>
+<td>Source</td>
+<td>Target</td>
+<td align="right">Retained bytes</td>
+</tr>
+{{range .Edges}}
+<tr>
+<td>{{.Source}}</td>
+<td>{{.Target}}</td>
+<td align="right">{{.Retained}}</td>
+</tr>
+{{end}}
+</table>
+</tt>
+</body>
+</html>
+`))
+
+func (v *viewer) conservativeHandler(w http.ResponseWriter, r *http.Request) {
+	d := v.d
+	var c []consEntry
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		if d.Ft(x).Kind != read.TypeKindConservative {
+			continue
+		}
+		for _, e := range d.Edges(x) {
+			// Only edges from the immediate dominator of their
+			// target add retained size.
+			if !e.Conservative || d.Idom(e.To) != x {
+				continue
+			}
+			c = append(c, consEntry{template.HTML(v.edgeSource(x, e)), template.HTML(v.edgeLink(e)), d.RetainedSize(e.To)})
+		}
+	}
+	sort.Sort(byConsRetained(c))
+	if len(c) > maxConservative {
+		c = c[:maxConservative]
+	}
+	resolved, words := d.ConservativeDensity()
+	p := 0.0
+	if words > 0 {
+		p = 100 * float64(resolved) / float64(words)
+	}
+	page := consPage{resolved, words, fmt.Sprintf("%.1f%%", p), c}
+	if err := conservativeTemplate.Execute(w, page); err != nil {
+		log.Print(err)
+	}
+}
+
+type byConsRetained []consEntry
+
+func (a byConsRetained) Len() int           { return len(a) }
+func (a byConsRetained) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byConsRetained) Less(i, j int) bool { return a[i].Retained > a[j].Retained }
+
+// defaultHogs is the number of objects shown on the memory hogs page
+// unless overridden by its n parameter.
+const defaultHogs = 20
+
+type hogEntry struct {
+	Obj      template.HTML
+	Retained uint64
+	Percent  string
+}
+
+var hogsTemplate = template.Must(template.New("hogs").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Memory hogs</title>
+</head>
+<body>
+<tt>
+<h2>Memory hogs</h2>
+The objects retaining the most memory, excluding any object
+dominated by another one in the list.
+<table>
+<tr>
+<td>Object</td>
+<td align="right">Retained bytes</td>
+<td align="right">% of heap</td>
+</tr>
+{{range .}}
+<tr>
+<td>{{.Obj}}</td>
+<td align="right">{{.Retained}}</td>
+<td align="right">{{.Percent}}</td>
+</tr>
+{{end}}
+</table>
+</tt>
+</body>
+</html>
+`))
+
+func (v *viewer) hogsHandler(w http.ResponseWriter, r *http.Request) {
+	d := v.d
+	n := defaultHogs
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		n, err = strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, err.Error(), 405)
+			return
+		}
+		if n < 1 {
+			n = 1
+		}
+	}
+	var h []hogEntry
+	for _, x := range d.Hogs(n) {
+		h = append(h, hogEntry{template.HTML(v.objLink(x)), d.RetainedSize(x), v.percent(d.RetainedSize(x))})
+	}
+	if err := hogsTemplate.Execute(w, h); err != nil {
+		log.Print(err)
+	}
+}
+
+type rootSplitEntry struct {
+	Kind    string
+	Bytes   uint64
+	Percent string
+}
+
+var rootSplitTemplate = template.Must(template.New("rootsplit").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Retained by root kind</title>
+</head>
+<body>
+<tt>
+<h2>Retained by root kind</h2>
+The live heap, split by the kind of root keeping it alive.  Memory
+reachable from more than one kind of root is counted as shared.
+<table>
+<tr>
+<td>Root kind</td>
+<td align="right">Retained bytes</td>
+<td align="right">Percent</td>
+</tr>
+{{range .}}
+<tr>
+<td>{{.Kind}}</td>
+<td align="right">{{.Bytes}}</td>
+<td align="right">{{.Percent}}</td>
+</tr>
+{{end}}
+</table>
+</tt>
+</body>
+</html>
+`))
+
+func (v *viewer) rootSplitHandler(w http.ResponseWriter, r *http.Request) {
+	split := v.d.RetainedByRoot()
+	var total uint64
+	for _, b := range split {
+		total += b
+	}
+	var e []rootSplitEntry
+	for _, k := range []string{read.RootStacks, read.RootGlobals, read.RootOther, read.RootFinalizers, read.RootShared} {
+		p := 0.0
+		if total > 0 {
+			p = 100 * float64(split[k]) / float64(total)
+		}
+		e = append(e, rootSplitEntry{k, split[k], fmt.Sprintf("%.1f%%", p)})
+	}
+	if err := rootSplitTemplate.Execute(w, e); err != nil {
+		log.Print(err)
+	}
+}
+
+// maxMaps is the number of maps shown on the maps page.
+const maxMaps = 100
+
+// bucketCnt is the number of entries in a map bucket.
+const bucketCnt = 8
+
+type mapEntry struct {
+	Obj         template.HTML
+	Count       uint64 // entries in the map
+	Buckets     uint64 // 1<<B
+	Capacity    uint64 // entries the buckets hold
+	Load        string // Count as a percentage of Capacity
+	BucketBytes uint64 // size of the bucket arrays, old and new
+}
+
+var mapsTemplate = template.Must(template.New("maps").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Large maps</title>
+</head>
+<body>
+<tt>
+<h2>Large maps</h2>
+Maps by the size of their bucket arrays.  A map with a low load factor
+has grown and then shrunk, and could be recreated to use less memory.
+Maps are only recognized in dumps named using the executable.
+<table>
+<tr>
+<td>Map</td>
+<td align="right">Entries</td>
+<td align="right">Buckets</td>
+<td align="right">Capacity</td>
+<td align="right">Load</td>
+<td align="right">Bucket bytes</td>
+</tr>
+{{range .}}
+<tr>
+<td>{{.Obj}}</td>
+<td align="right">{{.Count}}</td>
+<td align="right">{{.Buckets}}</td>
+<td align="right">{{.Capacity}}</td>
+<td align="right">{{.Load}}</td>
+<td align="right">{{.BucketBytes}}</td>
+</tr>
+{{end}}
+</table>
+</tt>
+</body>
+</html>
+`))
+
+// mapsHandler lists the maps with the largest bucket arrays.  Map
+// headers are recognized by their type name, and their fields by the
+// names the DWARF info gives them.
+func (v *viewer) mapsHandler(w http.ResponseWriter, r *http.Request) {
+	d := v.d
+	var m []mapEntry
+	for _, ft := range d.FTList {
+		if !strings.HasPrefix(ft.Name, "map.hdr[") {
+			continue
+		}
+		var count, b, buckets, oldbuckets *read.Field
+		for i := range ft.Fields {
+			f := &ft.Fields[i]
+			switch f.Name {
+			case "count":
+				count = f
+			case "B":
+				b = f
+			case "buckets":
+				buckets = f
+			case "oldbuckets":
+				oldbuckets = f
+			}
+		}
+		if count == nil || b == nil || buckets == nil {
+			continue
+		}
+		for _, x := range v.byType[ft.Id].objects {
+			data := d.Contents(x)
+			lg := v.readUint(data[b.Offset:], b.Kind)
+			if lg > 8*d.PtrSize {
+				continue // not a real map header
+			}
+			var e mapEntry
+			e.Obj = template.HTML(v.objLink(x))
+			e.Count = uint64(v.readInt(data[count.Offset:], count.Kind))
+			e.Buckets = 1 << lg
+			e.Capacity = bucketCnt * e.Buckets
+			e.Load = fmt.Sprintf("%.1f%%", 100*float64(e.Count)/float64(e.Capacity))
+			for _, f := range []*read.Field{buckets, oldbuckets} {
+				if f == nil {
+					continue
+				}
+				if y := d.FindObj(d.ReadPtr(data[f.Offset:])); y != read.ObjNil {
+					e.BucketBytes += d.Size(y)
+				}
+			}
+			m = append(m, e)
+		}
+	}
+	sort.Stable(byBucketBytes(m))
+	if len(m) > maxMaps {
+		m = m[:maxMaps]
+	}
+	if err := mapsTemplate.Execute(w, m); err != nil {
+		log.Print(err)
+	}
+}
+
+// maxGroupObjects is the number of objects listed in each type group
+// of the dominator tree page.
+const maxGroupObjects = 100
+
+// A domGroup is the children of a node in the dominator tree which
+// have one type.
+type domGroup struct {
+	Type     template.HTML
+	Count    int
+	Retained uint64
+	Percent  string
+	Objects  []domObject // largest first
+	Elided   int         // objects not listed
+}
+
+type domObject struct {
+	Obj      template.HTML
+	Retained uint64
+	Percent  string
+	Kids     int    // number of objects it immediately dominates
+	Expand   string // url of its node in the tree
+}
+
+type domTreeInfo struct {
+	Path   []template.HTML // from the roots to this node
+	Total  uint64
+	Groups []domGroup
+}
+
+var domTreeTemplate = template.Must(template.New("dominatortree").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Dominator tree</title>
+</head>
+<body>
+<tt>
+<h2>Dominator tree</h2>
+{{range $i, $p := .Path}}{{if $i}} &gt; {{end}}{{$p}}{{end}}
+<br>
+{{.Total}} bytes retained, by the type of the objects retained directly.
+Open a type to see its objects, and follow an object's children link
+to move down the tree.
+{{range .Groups}}
+<details>
+<summary>{{.Type}}: {{.Count}} objects, {{.Retained}} bytes ({{.Percent}})</summary>
+<table>
+<tr>
+<td>Object</td>
+<td align="right">Retained bytes</td>
+<td align="right">% of heap</td>
+<td align="right">Children</td>
+</tr>
+{{range .Objects}}
+<tr>
+<td>{{.Obj}}</td>
+<td align="right">{{.Retained}}</td>
+<td align="right">{{.Percent}}</td>
+<td align="right">{{if .Kids}}<a href="{{.Expand}}">{{.Kids}}</a>{{else}}0{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{if .Elided}}
+<font color=Red>elided for display: {{.Elided}} objects</font>
+{{end}}
+</details>
+{{end}}
+</tt>
+</body>
+</html>
+`))
+
+// domTreeHandler shows a node of the dominator tree, the roots by
+// default, with its children grouped by type.
+func (v *viewer) domTreeHandler(w http.ResponseWriter, r *http.Request) {
+	d := v.d
+	x := read.ObjNil
+	if s := r.URL.Query().Get("id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), 405)
+			return
+		}
+		if id >= uint64(d.NumObjects()) {
+			http.Error(w, "object not found", 405)
+			return
+		}
+		x = read.ObjId(id)
+	}
+
+	var info domTreeInfo
+	for y := x; y != read.ObjNil; y = d.Idom(y) {
+		info.Path = append(info.Path, template.HTML(fmt.Sprintf("<a href=\"dominatortree?dump=%d&id=%d\">%x</a>", v.id, y, d.Addr(y))))
+	}
+	info.Path = append(info.Path, template.HTML(fmt.Sprintf("<a href=\"dominatortree?dump=%d\">roots</a>", v.id)))
+	for i, j := 0, len(info.Path)-1; i < j; i, j = i+1, j-1 {
+		info.Path[i], info.Path[j] = info.Path[j], info.Path[i]
+	}
+
+	// group the children by type
+	kids := map[int][]read.ObjId{}
+	var ids []int
+	for _, y := range d.Dominated(x) {
+		id := d.Ft(y).Id
+		if kids[id] == nil {
+			ids = append(ids, id)
+		}
+		kids[id] = append(kids[id], y)
+	}
+	for _, id := range ids {
+		objs := kids[id]
+		sort.Stable(byDomRetained{d, objs})
+		g := domGroup{Type: template.HTML(v.typeLink(d.FTList[id])), Count: len(objs)}
+		for i, y := range objs {
+			g.Retained += d.RetainedSize(y)
+			if i < maxGroupObjects {
+				g.Objects = append(g.Objects, domObject{template.HTML(v.objLink(y)), d.RetainedSize(y), v.percent(d.RetainedSize(y)),
+					len(d.Dominated(y)), fmt.Sprintf("dominatortree?dump=%d&id=%d", v.id, y)})
+			}
+		}
+		g.Elided = len(objs) - len(g.Objects)
+		g.Percent = v.percent(g.Retained)
+		info.Total += g.Retained
+		info.Groups = append(info.Groups, g)
+	}
+	sort.Stable(byGroupRetained(info.Groups))
+	if err := domTreeTemplate.Execute(w, info); err != nil {
+		log.Print(err)
+	}
+}
+
+type byDomRetained struct {
+	d    *read.Dump
+	objs []read.ObjId
+}
+
+func (a byDomRetained) Len() int      { return len(a.objs) }
+func (a byDomRetained) Swap(i, j int) { a.objs[i], a.objs[j] = a.objs[j], a.objs[i] }
+func (a byDomRetained) Less(i, j int) bool {
+	return a.d.RetainedSize(a.objs[i]) > a.d.RetainedSize(a.objs[j])
+}
+
+type byGroupRetained []domGroup
+
+func (a byGroupRetained) Len() int           { return len(a) }
+func (a byGroupRetained) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byGroupRetained) Less(i, j int) bool { return a[i].Retained > a[j].Retained }
+
+type byBucketBytes []mapEntry
+
+func (a byBucketBytes) Len() int           { return len(a) }
+func (a byBucketBytes) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byBucketBytes) Less(i, j int) bool { return a[i].BucketBytes > a[j].BucketBytes }
+
+// maxSharedArrays is the number of backing arrays shown on the shared
+// arrays page.
+const maxSharedArrays = 100
+
+type sharedArray struct {
+	Array  template.HTML
+	Type   template.HTML
+	Size   uint64
+	Slices int           // number of distinct objects and roots with slices of it
+	Owner  template.HTML // immediate dominator, which its size is attributed to
+}
+
+var sharedArraysTemplate = template.Must(template.New("sharedarrays").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Shared backing arrays</title>
+</head>
+<body>
+<tt>
+<h2>Shared backing arrays</h2>
+Arrays referred to by slices in more than one object or root, largest
+first.  Retained sizes charge the whole array to its owner, the
+immediate dominator, even though any of the slices may be keeping it
+alive.
+<table>
+<tr>
+<td>Array</td>
+<td>Type</td>
+<td align="right">Size</td>
+<td align="right">Slices</td>
+<td>Owner</td>
+</tr>
+{{range .}}
+<tr>
+<td>{{.Array}}</td>
+<td>{{.Type}}</td>
+<td align="right">{{.Size}}</td>
+<td align="right">{{.Slices}}</td>
+<td>{{.Owner}}</td>
+</tr>
+{{end}}
+</table>
+</tt>
+</body>
+</html>
+`))
+
+func (v *viewer) sharedArraysHandler(w http.ResponseWriter, r *http.Request) {
+	d := v.d
+	n := map[read.ObjId]int{} // number of distinct slice referrers
+	// Each root slice is a separate variable.
+	var roots [][]read.Edge
+	for _, f := range d.Frames {
+		roots = append(roots, f.Edges)
+	}
+	roots = append(roots, d.Data.Edges, d.Bss.Edges)
+	for _, edges := range roots {
+		for _, e := range edges {
+			if e.Kind == read.FieldKindSlice {
+				n[e.To]++
+			}
+		}
+	}
+	// An object with several slices of the same array counts once.
+	last := map[read.ObjId]read.ObjId{}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		for _, e := range d.Edges(x) {
+			if e.Kind != read.FieldKindSlice {
+				continue
+			}
+			if y, ok := last[e.To]; ok && y == x {
+				continue
+			}
+			last[e.To] = x
+			n[e.To]++
+		}
+	}
+
+	var a []sharedArray
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		c := n[x]
+		if c < 2 {
+			continue
+		}
+		owner := "root"
+		if y := d.Idom(x); y != read.ObjNil {
+			owner = v.objLink(y)
+		}
+		a = append(a, sharedArray{template.HTML(v.objLink(x)), template.HTML(v.typeLink(d.Ft(x))), d.Size(x), c, template.HTML(owner)})
+	}
+	sort.Stable(bySharedSize(a))
+	if len(a) > maxSharedArrays {
+		a = a[:maxSharedArrays]
+	}
+	if err := sharedArraysTemplate.Execute(w, a); err != nil {
+		log.Print(err)
+	}
+}
+
+type bySharedSize []sharedArray
+
+func (a bySharedSize) Len() int           { return len(a) }
+func (a bySharedSize) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a bySharedSize) Less(i, j int) bool { return a[i].Size > a[j].Size }
+
+type rawParamsInfo struct {
+	Start      int64
+	End        int64
+	Order      string
+	PtrSize    uint64
+	HChanSize  uint64
+	HeapStart  uint64
+	HeapEnd    uint64
+	TheChar    string
+	Experiment string
+	Ncpu       uint64
+}
+
+var rawParamsTemplate = template.Must(template.New("rawparams").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Dump parameters</title>
+</head>
+<body>
+<tt>
+<h2>Dump parameters</h2>
+The params record, as decoded, at offsets {{.Start}} to {{.End}} of the dump.
+<table>
+<tr><td>Byte order</td><td>{{.Order}}</td></tr>
+<tr><td>Pointer size</td><td>{{.PtrSize}}</td></tr>
+<tr><td>Channel header size</td><td>{{.HChanSize}}</td></tr>
+<tr><td>Heap start</td><td>{{printf "%x" .HeapStart}}</td></tr>
+<tr><td>Heap end</td><td>{{printf "%x" .HeapEnd}}</td></tr>
+<tr><td>Architecture character</td><td>{{.TheChar}}</td></tr>
+<tr><td>Experiments</td><td>{{.Experiment}}</td></tr>
+<tr><td>CPUs</td><td>{{.Ncpu}}</td></tr>
+</table>
+</tt>
+</body>
+</html>
+`))
+
+// rawParamsHandler shows what was read from the dump's params record,
+// for diagnosing changes in the dump format.
+func (v *viewer) rawParamsHandler(w http.ResponseWriter, r *http.Request) {
+	d := v.d
+	order := "little endian"
+	if d.Order == binary.BigEndian {
+		order = "big endian"
+	}
+	info := rawParamsInfo{d.ParamsStart, d.ParamsEnd, order, d.PtrSize, d.HChanSize, d.HeapStart, d.HeapEnd,
+		strconv.QuoteRune(rune(d.TheChar)), d.Experiment, d.Ncpu}
+	if err := rawParamsTemplate.Execute(w, info); err != nil {
+		log.Print(err)
+	}
+}
+
+type symbolEntry struct {
+	Start string
+	End   string
+	Size  uint64
+	Name  string
+}
+
+type symbolsInfo struct {
+	Dump    int
+	Filter  string
+	Total   int
+	Elided  int
+	Symbols []symbolEntry
+}
+
+var symbolsTemplate = template.Must(template.New("symbols").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Symbols</title>
+</head>
+<body>
+<tt>
+<h2>Symbols</h2>
+{{.Total}} symbols loaded from the executable.
+<form action="symbols">
+<input type="hidden" name="dump" value="{{.Dump}}">
+name contains <input type="text" name="name" value="{{.Filter}}">
+<input type="submit" value="Filter">
+</form>
+<table>
+<tr>
+<td>Start</td>
+<td>End</td>
+<td align="right">Size</td>
+<td>Name</td>
+</tr>
+{{range .Symbols}}
+<tr>
+<td>{{.Start}}</td>
+<td>{{.End}}</td>
+<td align="right">{{.Size}}</td>
+<td>{{.Name}}</td>
+</tr>
+{{end}}
+</table>
+{{if .Elided}}
+<font color=Red>elided for display: {{.Elided}} symbols</font>
+{{end}}
+</tt>
+</body>
+</html>
+`))
+
+// symbolsHandler lists the address ranges of the executable's symbols,
+// which non-heap pointers are named by.
+func (v *viewer) symbolsHandler(w http.ResponseWriter, r *http.Request) {
+	syms := v.d.Symbols()
+	info := symbolsInfo{Dump: v.id, Filter: r.URL.Query().Get("name"), Total: len(syms)}
+	for _, s := range syms {
+		if info.Filter != "" && !strings.Contains(s.Name, info.Filter) {
+			continue
+		}
+		if len(info.Symbols) == *maxFields {
+			info.Elided++
+			continue
+		}
+		info.Symbols = append(info.Symbols, symbolEntry{fmt.Sprintf("%x", s.Addr), fmt.Sprintf("%x", s.Addr+s.Size), s.Size, s.Name})
+	}
+	if err := symbolsTemplate.Execute(w, info); err != nil {
+		log.Print(err)
+	}
+}
+
+// sessionCookie names the cookie identifying a browser's set of pins.
+const sessionCookie = "hview-session"
+
+// session returns the session id of the request, setting a new one
+// on the response if it has none.
+func (s *server) session(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	id := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: id, Path: "/"})
+	return id
+}
+
+// pinHandler adds the object given by the dump and id parameters to
+// the session's pins, or removes it if the op parameter is "remove".
+// It then sends the browser back where it came from.
+func (s *server) pinHandler(w http.ResponseWriter, r *http.Request) {
+	v := s.viewerFor(w, r)
+	if v == nil {
+		return
+	}
+	q := r.URL.Query()
+	id, err := strconv.ParseUint(q.Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), 405)
+		return
+	}
+	if id >= uint64(v.d.NumObjects()) {
+		http.Error(w, "object not found", 405)
+		return
+	}
+	p := pin{v.id, read.ObjId(id)}
+	sess := s.session(w, r)
+
+	s.mu.Lock()
+	if s.pins == nil {
+		s.pins = map[string]map[pin]bool{}
+	}
+	m := s.pins[sess]
+	if m == nil {
+		m = map[pin]bool{}
+		s.pins[sess] = m
+	}
+	if q.Get("op") == "remove" {
+		delete(m, p)
+	} else {
+		m[p] = true
+	}
+	s.mu.Unlock()
+
+	http.Redirect(w, r, localReferer(r, "/pinned"), http.StatusSeeOther)
+}
+
+// localReferer returns the path and query of the page on this server
+// that r came from, or def if there isn't one.  Other sites' pages
+// are never returned, so links can't make us redirect off-site.
+func localReferer(r *http.Request, def string) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil || u.Host != r.Host || u.Opaque != "" || !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") {
+		return def
+	}
+	return u.RequestURI()
+}
+
+type pinEntry struct {
+	Dump     string
+	Obj      template.HTML
+	Typ      template.HTML
+	Size     uint64
+	Retained uint64
+	Remove   string
+}
+
+var pinnedTemplate = template.Must(template.New("pinned").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Pinned objects</title>
+</head>
+<body>
+<tt>
+<h2>Pinned objects</h2>
+<table>
+<tr>
+<td>Dump</td>
+<td>Object</td>
+<td>Type</td>
+<td align="right">Size</td>
+<td align="right">Retained bytes</td>
+<td></td>
+</tr>
+{{range .}}
+<tr>
+<td>{{.Dump}}</td>
+<td>{{.Obj}}</td>
+<td>{{.Typ}}</td>
+<td align="right">{{.Size}}</td>
+<td align="right">{{.Retained}}</td>
+<td><a href="{{.Remove}}">unpin</a></td>
+</tr>
+{{end}}
+</table>
+</tt>
+</body>
+</html>
+`))
+
+// pinnedHandler lists the objects pinned in the request's session.
+func (s *server) pinnedHandler(w http.ResponseWriter, r *http.Request) {
+	sess := s.session(w, r)
+	s.mu.Lock()
+	var pins []pin
+	for p := range s.pins[sess] {
+		pins = append(pins, p)
+	}
+	s.mu.Unlock()
+	sort.Sort(byPin(pins))
+
+	s.work <- true
+	defer func() { <-s.work }()
+	var e []pinEntry
+	for _, p := range pins {
+		e = append(e, s.viewers[p.dump].pinEntry(p))
+	}
+	if err := pinnedTemplate.Execute(w, e); err != nil {
+		log.Print(err)
+	}
+}
+
+// pinEntry returns the row of the pinned page for p, which must be an
+// object of v.
+func (v *viewer) pinEntry(p pin) pinEntry {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	d := v.d
+	return pinEntry{
+		v.name,
+		template.HTML(v.objLink(p.id)),
+		template.HTML(v.typeLink(d.Ft(p.id))),
+		d.Size(p.id),
+		d.RetainedSize(p.id),
+		fmt.Sprintf("pin?dump=%d&id=%d&op=remove", p.dump, p.id),
+	}
+}
+
+type byPin []pin
+
+func (a byPin) Len() int      { return len(a) }
+func (a byPin) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byPin) Less(i, j int) bool {
+	if a[i].dump != a[j].dump {
+		return a[i].dump < a[j].dump
+	}
+	return a[i].id < a[j].id
+}
+
+type sizeClassEntry struct {
+	Size   uint64
+	Count  int
+	Bytes  uint64
+	Wasted uint64 // bytes lost to rounding up to the size class
+}
+
+var sizeClassTemplate = template.Must(template.New("sizeclass").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Size classes</title>
+</head>
+<body>
+<tt>
+<h2>Size classes</h2>
+Wasted bytes are the difference between the size class of an object
+and the size of its type.  Objects without type information are
+not included in the waste.
+<table>
+<tr>
+<td align="right">Size</td>
+<td align="right">Count</td>
+<td align="right">Bytes</td>
+<td align="right">Wasted bytes</td>
+</tr>
+{{range .}}
+<tr>
+<td align="right">{{.Size}}</td>
+<td align="right">{{.Count}}</td>
+<td align="right">{{.Bytes}}</td>
+<td align="right">{{.Wasted}}</td>
+</tr>
+{{end}}
+</table>
+</tt>
+</body>
+</html>
+`))
+
+// typeBytes returns the number of bytes of an object of full type ft
+// which are used by its type, or 0 if it is not known.
+func (v *viewer) typeBytes(ft *read.FullType) uint64 {
+	t := ft.Typ
+	if t == nil {
+		return 0
+	}
+	switch ft.Kind {
+	case read.TypeKindObject:
+		return t.Size
+	case read.TypeKindArray:
+		if t.Size == 0 {
+			return 0
+		}
+		return ft.Size / t.Size * t.Size
+	case read.TypeKindChan:
+		if t.Size == 0 {
+			return v.d.HChanSize
+		}
+		return v.d.HChanSize + (ft.Size-v.d.HChanSize)/t.Size*t.Size
+	}
+	return 0
+}
+
+func (v *viewer) sizeClassHandler(w http.ResponseWriter, r *http.Request) {
+	d := v.d
+	m := map[uint64]*sizeClassEntry{}
+	for _, ft := range d.FTList {
+		b := v.byType[ft.Id]
+		if len(b.objects) == 0 {
+			continue
+		}
+		e := m[ft.Size]
+		if e == nil {
+			e = &sizeClassEntry{Size: ft.Size}
+			m[ft.Size] = e
+		}
+		e.Count += len(b.objects)
+		e.Bytes += b.bytes
+		if n := v.typeBytes(ft); n != 0 && n <= ft.Size {
+			e.Wasted += (ft.Size - n) * uint64(len(b.objects))
+		}
+	}
+	var c []sizeClassEntry
+	for _, e := range m {
+		c = append(c, *e)
+	}
+	sort.Sort(bySize(c))
+	if err := sizeClassTemplate.Execute(w, c); err != nil {
+		log.Print(err)
+	}
+}
+
+type bySize []sizeClassEntry
+
+func (a bySize) Len() int           { return len(a) }
+func (a bySize) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a bySize) Less(i, j int) bool { return a[i].Size < a[j].Size }
+
+type goListInfo struct {
+	Name  template.HTML
+	State string
+	Wait  string // how long it has been waiting, if known
+}
+
+var goListTemplate = template.Must(template.New("golist").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Goroutines</title>
+</head>
+<body>
+<tt>
+<h2>Goroutines</h2>
+<a href="goroutines?dump={{.Dump}}&group=1">Group by stack</a>
+<form action="goroutines">
+<input type="hidden" name="dump" value="{{.Dump}}">
+waiting at least <input type="text" name="waitms" value="{{.WaitMs}}"> ms
+<input type="submit" value="Filter">
+</form>
+{{if .WaitMs}}
+Goroutines with no known wait time are always shown.
+{{end}}
+<table>
+<tr>
+<td>Name</td>
+<td>State</td>
+<td>Waiting</td>
+</tr>
+{{range .Goroutines}}
+<tr>
+<td>{{.Name}}</td>
+<td>{{.State}}</td>
+<td>{{.Wait}}</td>
+</tr>
+{{end}}
+</table>
+</tt>
+</body>
+</html>
+`))
+
+type goListPage struct {
+	Dump       int
+	WaitMs     string // minimum wait time filter, if any
+	Goroutines []goListInfo
+}
+
+func (v *viewer) goListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("group") == "1" {
+		v.goGroupHandler(w, r)
+		return
+	}
+	// Hide goroutines known to have waited less than waitms.
+	waitms := r.URL.Query().Get("waitms")
+	var minWait time.Duration
+	if waitms != "" {
+		ms, err := strconv.ParseUint(waitms, 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), 405)
+			return
+		}
+		minWait = time.Duration(ms) * time.Millisecond
+	}
+	var i []goListInfo
+	for _, g := range v.d.Goroutines {
+		t, ok := v.d.WaitTime(g)
+		if ok && t < minWait {
+			continue
+		}
+		name := fmt.Sprintf("<a href=\"go?dump=%d&id=%x\">goroutine %x</a>", v.id, g.Addr, g.Addr)
+		var state string
+		switch g.Status {
+		case 0:
+			state = "idle"
+		case 1:
+			state = "runnable"
+		case 2:
+			// running - shouldn't happen
+			log.Fatal("found running goroutine in heap dump")
+		case 3:
+			state = "syscall"
+		case 4:
+			state = g.WaitReason
+		case 5:
+			state = "dead"
+		default:
+			log.Fatal("unknown goroutine status")
+		}
+		var wait string
+		if ok {
+			wait = t.String()
 		}
+		i = append(i, goListInfo{template.HTML(name), state, wait})
 	}
-	if err := othersTemplate.Execute(w, f); err != nil {
+	// sort by state
+	sort.Sort(ByState(i))
+	if err := goListTemplate.Execute(w, goListPage{v.id, waitms, i}); err != nil {
 		log.Print(err)
 	}
 }
 
-type goListInfo struct {
-	Name  string
-	State string
+type goGroup struct {
+	Count      int
+	Stack      []string // function names, innermost first
+	Goroutines []template.HTML
 }
 
-var goListTemplate = template.Must(template.New("golist").Parse(`
+// maxGroupLinks is the number of goroutines listed for each stack group.
+const maxGroupLinks = 10
+
+var goGroupTemplate = template.Must(template.New("gogroup").Parse(`
 <html>
 <head>
 <style>
@@ -598,20 +3160,22 @@ table, td, th
 border:1px solid grey;
 }
 </style>
-<title>Goroutines</title>
+<title>Goroutines by stack</title>
 </head>
 <body>
 <tt>
-<h2>Goroutines</h2>
+<h2>Goroutines by stack</h2>
 <table>
 <tr>
-<td>Name</td>
-<td>State</td>
+<td align="right">Count</td>
+<td>Stack</td>
+<td>Goroutines</td>
 </tr>
 {{range .}}
 <tr>
-<td>{{.Name}}</td>
-<td>{{.State}}</td>
+<td align="right">{{.Count}}</td>
+<td>{{range .Stack}}{{.}}<br>{{end}}</td>
+<td>{{range .Goroutines}}{{.}}<br>{{end}}</td>
 </tr>
 {{end}}
 </table>
@@ -620,37 +3184,49 @@ border:1px solid grey;
 </html>
 `))
 
-func goListHandler(w http.ResponseWriter, r *http.Request) {
-	var i []goListInfo
-	for _, g := range d.Goroutines {
-		name := fmt.Sprintf("<a href=go?id=%x>goroutine %x</a>", g.Addr, g.Addr)
-		var state string
-		switch g.Status {
-		case 0:
-			state = "idle"
-		case 1:
-			state = "runnable"
-		case 2:
-			// running - shouldn't happen
-			log.Fatal("found running goroutine in heap dump")
-		case 3:
-			state = "syscall"
-		case 4:
-			state = g.WaitReason
-		case 5:
-			state = "dead"
-		default:
-			log.Fatal("unknown goroutine status")
+// goGroupHandler shows the goroutines grouped by their stack signature,
+// the sequence of function names on their stack.
+func (v *viewer) goGroupHandler(w http.ResponseWriter, r *http.Request) {
+	groups := map[string]*goGroup{}
+	for _, g := range v.d.Goroutines {
+		var stack []string
+		for f := g.Bos; f != nil; f = f.Parent {
+			stack = append(stack, f.Name)
+		}
+		sig := strings.Join(stack, "\n")
+		gg := groups[sig]
+		if gg == nil {
+			gg = &goGroup{Stack: stack}
+			groups[sig] = gg
+		}
+		gg.Count++
+		if len(gg.Goroutines) < maxGroupLinks {
+			gg.Goroutines = append(gg.Goroutines, template.HTML(fmt.Sprintf("<a href=\"go?dump=%d&id=%x\">goroutine %x</a>", v.id, g.Addr, g.Addr)))
+		} else if len(gg.Goroutines) == maxGroupLinks {
+			gg.Goroutines = append(gg.Goroutines, "...")
 		}
-		i = append(i, goListInfo{name, state})
 	}
-	// sort by state
-	sort.Sort(ByState(i))
-	if err := goListTemplate.Execute(w, i); err != nil {
+	var i []goGroup
+	for _, gg := range groups {
+		i = append(i, *gg)
+	}
+	sort.Sort(byCount(i))
+	if err := goGroupTemplate.Execute(w, i); err != nil {
 		log.Print(err)
 	}
 }
 
+type byCount []goGroup
+
+func (a byCount) Len() int      { return len(a) }
+func (a byCount) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byCount) Less(i, j int) bool {
+	if a[i].Count != a[j].Count {
+		return a[i].Count > a[j].Count
+	}
+	return strings.Join(a[i].Stack, "\n") < strings.Join(a[j].Stack, "\n")
+}
+
 type ByState []goListInfo
 
 func (a ByState) Len() int           { return len(a) }
@@ -658,10 +3234,25 @@ func (a ByState) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByState) Less(i, j int) bool { return a[i].State < a[j].State }
 
 type goInfo struct {
+	Dump   int
 	Addr   uint64
 	Obj    read.ObjId
 	State  string
-	Frames []string
+	Frames []template.HTML
+	Defers []deferEntry
+	Panics []panicEntry
+}
+
+type deferEntry struct {
+	Addr string
+	Func string // deferred function
+	Pc   string // where it was deferred
+}
+
+type panicEntry struct {
+	Addr string
+	Type string // type of the panic value
+	Data string // data word of the panic value
 }
 
 var goTemplate = template.Must(template.New("go").Parse(`
@@ -681,26 +3272,63 @@ border:1px solid grey;
 </head>
 <body>
 <tt>
-<h2>Goroutine <a href=obj?id={{.Obj}}>{{printf "%x" .Addr}}</a></h2>
+<h2>Goroutine <a href="obj?dump={{.Dump}}&id={{.Obj}}">{{printf "%x" .Addr}}</a></h2>
 <h3>{{.State}}</h3>
 <h3>Stack</h3>
 {{range .Frames}}
 {{.}}
 <br>
 {{end}}
+{{if .Defers}}
+<h3>Pending defers</h3>
+In the order they will run.
+<table>
+<tr>
+<td>Defer</td>
+<td>Function</td>
+<td>Deferred at</td>
+</tr>
+{{range .Defers}}
+<tr>
+<td>{{.Addr}}</td>
+<td>{{.Func}}</td>
+<td>{{.Pc}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+{{if .Panics}}
+<h3>Active panics</h3>
+Most recent first.
+<table>
+<tr>
+<td>Panic</td>
+<td>Type</td>
+<td>Data</td>
+</tr>
+{{range .Panics}}
+<tr>
+<td>{{.Addr}}</td>
+<td>{{.Type}}</td>
+<td>{{.Data}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
 </tt>
 </body>
 </html>
 `))
 
-func goHandler(w http.ResponseWriter, r *http.Request) {
+func (v *viewer) goHandler(w http.ResponseWriter, r *http.Request) {
+	d := v.d
 	q := r.URL.Query()
-	v := q["id"]
-	if len(v) != 1 {
+	s := q["id"]
+	if len(s) != 1 {
 		http.Error(w, "id parameter missing", 405)
 		return
 	}
-	addr, err := strconv.ParseUint(v[0], 16, 64)
+	addr, err := strconv.ParseUint(s[0], 16, 64)
 	if err != nil {
 		http.Error(w, err.Error(), 405)
 		return
@@ -718,6 +3346,7 @@ func goHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var i goInfo
+	i.Dump = v.id
 	i.Addr = g.Addr
 	i.Obj = d.FindObj(g.Addr)
 	switch g.Status {
@@ -739,7 +3368,17 @@ func goHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for f := g.Bos; f != nil; f = f.Parent {
-		i.Frames = append(i.Frames, fmt.Sprintf("<a href=frame?id=%x&depth=%d>%s</a>", f.Addr, f.Depth, f.Name))
+		i.Frames = append(i.Frames, template.HTML(fmt.Sprintf("<a href=\"frame?dump=%d&id=%x&depth=%d\">%s</a>", v.id, f.Addr, f.Depth, html.EscapeString(f.Name))))
+	}
+	for _, x := range g.Defers() {
+		i.Defers = append(i.Defers, deferEntry{fmt.Sprintf("%x", x.Addr), v.pcName(x.Code), v.pcName(x.Pc)})
+	}
+	for _, x := range g.Panics() {
+		typ := fmt.Sprintf("%x", x.Typ)
+		if t := d.TypeMap[x.Typ]; t != nil {
+			typ = t.Name
+		}
+		i.Panics = append(i.Panics, panicEntry{fmt.Sprintf("%x", x.Addr), typ, fmt.Sprintf("%x", x.Data)})
 	}
 
 	if err := goTemplate.Execute(w, i); err != nil {
@@ -747,12 +3386,31 @@ func goHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// pcName returns the function containing pc and the offset into it,
+// or just pc if the executable wasn't given.
+func (v *viewer) pcName(pc uint64) string {
+	if name, off, ok := v.d.SymbolAt(pc); ok {
+		return fmt.Sprintf("%s+%#x", name, off)
+	}
+	return fmt.Sprintf("%x", pc)
+}
+
 type frameInfo struct {
+	Dump      int
 	Addr      uint64
 	Name      string
 	Depth     uint64
-	Goroutine string
+	Goroutine template.HTML
 	Vars      []Field
+	Raw       []rawWord
+}
+
+// A rawWord is a row of the raw view of a frame's memory.
+type rawWord struct {
+	Addr   uint64
+	Offset uint64        // from the bottom of the frame
+	CFA    string        // offset from the canonical frame address, which DWARF locations use
+	Bytes  template.HTML // hex and ASCII
 }
 
 var frameTemplate = template.Must(template.New("frame").Parse(`
@@ -773,7 +3431,8 @@ border:1px solid grey;
 <body>
 <tt>
 <h2>Frame {{.Name}}</h2>
-<h3>In {{.Goroutine}}</h3>
+{{if .Goroutine}}<h3>In {{.Goroutine}}</h3>{{end}}
+<a href="func?dump={{.Dump}}&name={{.Name}}">All frames of this function</a>
 <h3>Variables</h3>
 <table>
 <tr>
@@ -789,19 +3448,37 @@ border:1px solid grey;
 </tr>
 {{end}}
 </table>
+<h3>Raw memory</h3>
+<table>
+<tr>
+<td>Address</td>
+<td align="right">Offset</td>
+<td align="right">CFA offset</td>
+<td>Bytes</td>
+</tr>
+{{range .Raw}}
+<tr>
+<td>{{printf "%x" .Addr}}</td>
+<td align="right">{{.Offset}}</td>
+<td align="right">{{.CFA}}</td>
+<td>{{.Bytes}}</td>
+</tr>
+{{end}}
+</table>
 </tt>
 </body>
 </html>
 `))
 
-func frameHandler(w http.ResponseWriter, r *http.Request) {
+func (v *viewer) frameHandler(w http.ResponseWriter, r *http.Request) {
+	d := v.d
 	q := r.URL.Query()
-	v := q["id"]
-	if len(v) != 1 {
+	s := q["id"]
+	if len(s) != 1 {
 		http.Error(w, "id parameter missing", 405)
 		return
 	}
-	addr, err := strconv.ParseUint(v[0], 16, 64)
+	addr, err := strconv.ParseUint(s[0], 16, 64)
 	if err != nil {
 		http.Error(w, err.Error(), 405)
 		return
@@ -830,19 +3507,100 @@ func frameHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var i frameInfo
+	i.Dump = v.id
 	i.Addr = f.Addr
 	i.Name = f.Name
 	i.Depth = f.Depth
-	i.Goroutine = fmt.Sprintf("<a href=go?id=%x>goroutine %x</a>", f.Goroutine.Addr, f.Goroutine.Addr)
+	// frames of goroutines dropped for lacking a bottom frame have none
+	if g := f.Goroutine; g != nil {
+		i.Goroutine = template.HTML(fmt.Sprintf("<a href=\"go?dump=%d&id=%x\">goroutine %x</a>", v.id, g.Addr, g.Addr))
+	}
 
 	// variables
-	i.Vars = getFields(f.Data, f.Fields, f.Edges)
+	i.Vars = v.getFields(f.Data, uint64(len(f.Data)), f.Fields, f.Edges)
+
+	// raw memory, a word per row.  The frame ends at the CFA.
+	n := uint64(len(f.Data))
+	for off := uint64(0); off < n; off += d.PtrSize {
+		end := off + d.PtrSize
+		if end > n {
+			end = n
+		}
+		i.Raw = append(i.Raw, rawWord{f.Addr + off, off, fmt.Sprintf("-%d", n-off), template.HTML(rawBytes(f.Data[off:end]))})
+	}
 
 	if err := frameTemplate.Execute(w, i); err != nil {
 		log.Print(err)
 	}
 }
 
+type funcFrame struct {
+	Frame     template.HTML
+	Depth     uint64
+	Goroutine template.HTML
+}
+
+type funcInfo struct {
+	Name   string
+	Frames []funcFrame
+}
+
+var funcTemplate = template.Must(template.New("func").Parse(`
+<html>
+<head>
+<style>
+table
+{
+border-collapse:collapse;
+}
+table, td, th
+{
+border:1px solid grey;
+}
+</style>
+<title>Function {{.Name}}</title>
+</head>
+<body>
+<tt>
+<h2>Frames of {{.Name}}</h2>
+<table>
+<tr>
+<td>Frame</td>
+<td align="right">Depth</td>
+<td>Goroutine</td>
+</tr>
+{{range .Frames}}
+<tr>
+<td>{{.Frame}}</td>
+<td align="right">{{.Depth}}</td>
+<td>{{.Goroutine}}</td>
+</tr>
+{{end}}
+</table>
+</tt>
+</body>
+</html>
+`))
+
+// funcHandler lists the stack frames of a function and the goroutines
+// they belong to.
+func (v *viewer) funcHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	i := funcInfo{Name: name}
+	for _, f := range v.d.FramesByFunc(name) {
+		var e funcFrame
+		e.Frame = template.HTML(fmt.Sprintf("<a href=\"frame?dump=%d&id=%x&depth=%d\">frame %x</a>", v.id, f.Addr, f.Depth, f.Addr))
+		e.Depth = f.Depth
+		if g := f.Goroutine; g != nil {
+			e.Goroutine = template.HTML(fmt.Sprintf("<a href=\"go?dump=%d&id=%x\">goroutine %x</a>", v.id, g.Addr, g.Addr))
+		}
+		i.Frames = append(i.Frames, e)
+	}
+	if err := funcTemplate.Execute(w, i); err != nil {
+		log.Print(err)
+	}
+}
+
 // So meta.
 func heapdumpHandler(w http.ResponseWriter, r *http.Request) {
 	f, err := os.Create("metadump")
@@ -857,7 +3615,8 @@ func heapdumpHandler(w http.ResponseWriter, r *http.Request) {
 
 func usage() {
 	fmt.Fprintf(os.Stderr,
-		"usage: hview heapdump [executable]\n")
+		"usage: hview heapdump [executable] [heapdump [executable] ...]\n"+
+			"heapdump may be a named pipe, or - for standard input.\n")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
@@ -865,65 +3624,211 @@ func usage() {
 func main() {
 	flag.Usage = usage
 	flag.Parse()
+	if *skipNaming != "" {
+		read.SkipNaming = regexp.MustCompile(*skipNaming)
+	}
+	if *verbose {
+		read.DwarfLog.SetOutput(os.Stderr)
+	}
+	read.LoadRodata = *rodata
+	read.DebugDir = *debugDir
+
+	// Arguments are heap dumps, each optionally followed by the
+	// executable that produced it.
+	var cpuf *os.File
+	if *cpuProfile != "" {
+		var err error
+		cpuf, err = os.Create(*cpuProfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := pprof.StartCPUProfile(cpuf); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	var dump, exec string
+	var s server
+	if *workers < 1 {
+		log.Fatal("-workers must be at least 1")
+	}
+	s.work = make(chan bool, *workers)
 	args := flag.Args()
-	switch len(args) {
-	case 1:
-		dump = args[0]
-		exec = ""
-	case 2:
-		dump = args[0]
-		exec = args[1]
-	default:
+	if len(args) == 0 {
 		usage()
 		return
 	}
 
-	fmt.Println("Loading...")
-	d = read.Read(dump, exec)
+	// Serve /healthz while the dumps load.
+	ln, err := net.Listen("tcp", *httpAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		log.Fatal(http.Serve(ln, s.whenLoaded(s.mux())))
+	}()
+	// Each dump is loaded, then analyzed.
+	var ndumps int
+	for i := 0; i < len(args); i++ {
+		if isDump(args[i]) {
+			ndumps++
+		}
+	}
+	stages := float64(2 * ndumps)
+	for len(args) > 0 {
+		if !isDump(args[0]) {
+			usage()
+			return
+		}
+		dump, exec := args[0], ""
+		args = args[1:]
+		if len(args) > 0 && !isDump(args[0]) {
+			exec = args[0]
+			args = args[1:]
+		}
+
+		fmt.Printf("Loading %s...\n", dump)
+		s.setStatus(false, "loading "+dump, float64(2*len(s.viewers))/stages)
+		var d *read.Dump
+		var err error
+		switch {
+		case dump == "-":
+			d, err = read.ReadFrom(os.Stdin, exec)
+		case isStream(dump):
+			var f *os.File
+			f, err = os.Open(dump)
+			if err != nil {
+				log.Fatal(err)
+			}
+			d, err = read.ReadFrom(f, exec)
+			f.Close()
+		default:
+			d, err = read.Read(dump, exec)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *labels != "" && len(s.viewers) == 0 {
+			if err := d.LoadLabels(*labels); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		fmt.Println("Analyzing...")
+		s.setStatus(false, "analyzing "+dump, float64(2*len(s.viewers)+1)/stages)
+		s.viewers = append(s.viewers, newViewer(len(s.viewers), dump, d))
+	}
+
+	// Compare each histogram against the dump loaded before it, and
+	// the first against the second.
+	for i, v := range s.viewers {
+		switch {
+		case i > 0:
+			v.base = s.viewers[i-1]
+		case len(s.viewers) > 1:
+			v.base = s.viewers[1]
+		}
+	}
 
-	fmt.Println("Analyzing...")
-	prepare()
+	if cpuf != nil {
+		pprof.StopCPUProfile()
+		cpuf.Close()
+	}
+	if *memProfile != "" {
+		f, err := os.Create(*memProfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			log.Fatal(err)
+		}
+		f.Close()
+	}
 
+	s.setStatus(true, "ready", 1)
 	fmt.Println("Ready.  Point your browser to localhost" + *httpAddr)
-	http.HandleFunc("/", mainHandler)
-	http.HandleFunc("/obj", objHandler)
-	http.HandleFunc("/type", typeHandler)
-	http.HandleFunc("/histo", histoHandler)
-	http.HandleFunc("/globals", globalsHandler)
-	http.HandleFunc("/goroutines", goListHandler)
-	http.HandleFunc("/go", goHandler)
-	http.HandleFunc("/frame", frameHandler)
-	http.HandleFunc("/others", othersHandler)
-	http.HandleFunc("/heapdump", heapdumpHandler)
-	if err := http.ListenAndServe(*httpAddr, nil); err != nil {
-		log.Fatal(err)
+	select {}
+}
+
+// isStream reports whether the named file is a stream, either a named
+// pipe or "-" for standard input, that a heap dump is being written to.
+// Streams can't be sniffed or read at random, so we assume they hold
+// heap dumps and read them into memory.
+func isStream(filename string) bool {
+	if filename == "-" {
+		return true
 	}
+	fi, err := os.Stat(filename)
+	return err == nil && fi.Mode()&os.ModeNamedPipe != 0
 }
 
-// Map from object ID to list of objects that refer to that object.
-// It is split in two parts for efficiency.  If an object x has <= 1
-// inbound edge, we store it in ref1[x].  Otherwise, it is stored in ref2[x].
-// Since most objects have only one incoming reference,
-// ref2 ends up small.
-var ref1 []read.ObjId
-var ref2 map[read.ObjId][]read.ObjId
+// isDump reports whether the named file looks like a heap dump.
+func isDump(filename string) bool {
+	if isStream(filename) {
+		return true
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	hdr := "go1.3 heap dump\n"
+	b := make([]byte, len(hdr))
+	if _, err := io.ReadFull(f, b); err != nil {
+		return false
+	}
+	return string(b) == hdr
+}
 
-func getReferrers(x read.ObjId) []string {
-	var r []string
-	if y := ref1[x]; y != read.ObjNil {
-		for _, e := range d.Edges(y) {
-			if e.To == x {
-				r = append(r, edgeSource(y, e))
-			}
-		}
-		for _, y := range ref2[x] {
+// maxCachedReferrers is the number of objects whose referrers are
+// remembered, so that returning to an object is cheap.
+const maxCachedReferrers = 1024
+
+type cachedReferrers struct {
+	r, dead []string
+}
+
+// getReferrers returns html descriptions of the references to object
+// x.  Those from objects which are garbage themselves are returned
+// separately, in dead.  The results must not be modified.
+func (v *viewer) getReferrers(x read.ObjId) (r, dead []string) {
+	if c, ok := v.refCache[x]; ok {
+		return c.r, c.dead
+	}
+	r, dead = v.findReferrers(x)
+	if v.refCache == nil {
+		v.refCache = map[read.ObjId]cachedReferrers{}
+	}
+	if len(v.refOrder) == maxCachedReferrers {
+		delete(v.refCache, v.refOrder[0])
+		v.refOrder = v.refOrder[1:]
+	}
+	v.refCache[x] = cachedReferrers{r, dead}
+	v.refOrder = append(v.refOrder, x)
+	return r, dead
+}
+
+// findReferrers computes the result of getReferrers.
+func (v *viewer) findReferrers(x read.ObjId) (r, dead []string) {
+	d := v.d
+	holder := d.SoleHolder(x)
+	for _, y := range d.Referrers(x) {
+		if !d.Live(y) {
 			for _, e := range d.Edges(y) {
 				if e.To == x {
-					r = append(r, edgeSource(y, e))
+					dead = append(dead, v.edgeSource(y, e))
 				}
 			}
+			continue
+		}
+		cut := ""
+		if y == holder {
+			cut = fmt.Sprintf(" (only holder: cutting frees %d bytes)", d.RetainedSize(x))
+		}
+		for _, e := range d.Edges(y) {
+			if e.To == x {
+				r = append(r, v.edgeSource(y, e)+cut)
+			}
 		}
 	}
 	for _, s := range []*read.Data{d.Data, d.Bss} {
@@ -931,24 +3836,59 @@ func getReferrers(x read.ObjId) []string {
 			if e.To != x {
 				continue
 			}
-			r = append(r, "global "+e.FieldName)
+			r = append(r, "global "+html.EscapeString(e.FieldName))
 		}
 	}
 	for _, f := range d.Frames {
 		for _, e := range f.Edges {
 			if e.To == x {
-				r = append(r, fmt.Sprintf("<a href=frame?id=%x&depth=%d>%s</a>.%s", f.Addr, f.Depth, f.Name, e.FieldName))
+				r = append(r, fmt.Sprintf("<a href=\"frame?dump=%d&id=%x&depth=%d\">%s</a>.%s", v.id, f.Addr, f.Depth, html.EscapeString(f.Name), html.EscapeString(e.FieldName)))
 			}
 		}
 	}
 	for _, s := range d.Otherroots {
 		for _, e := range s.Edges {
 			if e.To == x {
-				r = append(r, s.Description)
+				r = append(r, html.EscapeString(s.Description))
 			}
 		}
 	}
-	return r
+	return r, dead
+}
+
+// A nameBucket totals the objects of the full types with one base
+// type name.  ft is the one with the most bytes, or nil if there are
+// no objects.
+type nameBucket struct {
+	ft    *read.FullType
+	count int
+	bytes uint64
+}
+
+// byName totals the objects of the full types whose base type is
+// named name.
+func (v *viewer) byName(name string) nameBucket {
+	var n nameBucket
+	for _, ft := range v.d.FullTypesByName(name) {
+		b := v.byType[ft.Id]
+		if len(b.objects) == 0 {
+			continue
+		}
+		if n.ft == nil || b.bytes > v.byType[n.ft.Id].bytes {
+			n.ft = ft
+		}
+		n.count += len(b.objects)
+		n.bytes += b.bytes
+	}
+	return n
+}
+
+// baseName returns the name FullTypesByName finds ft under.
+func baseName(ft *read.FullType) string {
+	if ft.Typ != nil {
+		return ft.Typ.Name
+	}
+	return ft.Name
 }
 
 type bucket struct {
@@ -956,12 +3896,11 @@ type bucket struct {
 	objects []read.ObjId
 }
 
-// histogram by full type id
-var byType []bucket
+func (v *viewer) prepare() {
+	d := v.d
 
-func prepare() {
 	// group objects by type
-	byType = make([]bucket, len(d.FTList))
+	byType := make([]bucket, len(d.FTList))
 	for i := 0; i < d.NumObjects(); i++ {
 		x := read.ObjId(i)
 		tid := d.Ft(x).Id
@@ -970,166 +3909,11 @@ func prepare() {
 		b.objects = append(b.objects, x)
 		byType[tid] = b
 	}
+	v.byType = byType
+	v.total = d.TotalBytes()
 
-	// compute referrers
-	ref1 = make([]read.ObjId, d.NumObjects())
-	for i := 0; i < d.NumObjects(); i++ {
-		ref1[i] = read.ObjNil
-	}
-	ref2 = map[read.ObjId][]read.ObjId{}
-	for i := 0; i < d.NumObjects(); i++ {
-		x := read.ObjId(i)
-		for _, e := range d.Edges(x) {
-			r := ref1[e.To]
-			if r == read.ObjNil {
-				ref1[e.To] = x
-			} else if x != r {
-				s := ref2[e.To]
-				if len(s) == 0 || x != s[len(s)-1] {
-					ref2[e.To] = append(s, x)
-				}
-			}
-		}
-	}
-
-	dom()
-}
-
-// map from object ID to the size of the heap that is dominated by that object.
-var domsize []uint64
-
-func dom() {
+	// Compute referrers and dominators up front, so the first page
+	// that needs them doesn't have to wait.
 	fmt.Println("Computing dominators...")
-	n := d.NumObjects()
-
-	// make list of roots
-	// TODO: have loader compute this?
-	roots := map[read.ObjId]struct{}{}
-	for _, s := range []*read.Data{d.Data, d.Bss} {
-		for _, e := range s.Edges {
-			roots[e.To] = struct{}{}
-		}
-	}
-	for _, f := range d.Frames {
-		for _, e := range f.Edges {
-			roots[e.To] = struct{}{}
-		}
-	}
-	for _, x := range d.Otherroots {
-		for _, e := range x.Edges {
-			roots[e.To] = struct{}{}
-		}
-	}
-
-	// compute postorder traversal
-	// object states:
-	// 0 - not seen yet
-	// 1 - seen, added to queue, not yet expanded children
-	// 2 - seen, already expanded children
-	// 3 - added to postorder
-	postorder := make([]read.ObjId, 0, n)
-	postnum := make([]int, n+1)
-	state := make([]byte, n)
-	var q []read.ObjId // stack of work to do, holds state 1 and 2 objects
-	for x := range roots {
-		if state[x] != 0 {
-			if state[x] != 3 {
-				log.Fatal("bad state found")
-			}
-			continue
-		}
-		state[x] = 1
-		q = q[:0]
-		q = append(q, x)
-		for len(q) > 0 {
-			y := q[len(q)-1]
-			if state[y] == 2 {
-				state[y] = 3
-				q = q[:len(q)-1]
-				postnum[y] = len(postorder)
-				postorder = append(postorder, y)
-			} else {
-				if state[y] != 1 {
-					log.Fatal("bad state")
-				}
-				state[y] = 2
-				for _, e := range d.Edges(y) {
-					z := e.To
-					if state[z] == 0 {
-						state[z] = 1
-						q = append(q, z)
-					}
-				}
-			}
-		}
-	}
-	postnum[n] = n // virtual start node
-
-	// compute immediate dominators
-	// http://www.hipersoft.rice.edu/grads/publications/dom14.pdf
-	idom := make([]read.ObjId, n+1)
-	for i := 0; i < n; i++ {
-		idom[i] = read.ObjNil
-	}
-	idom[n] = read.ObjId(n)
-	for r := range roots {
-		idom[r] = read.ObjId(n)
-	}
-	var redges []read.ObjId
-	change := true
-	for change {
-		change = false
-		for i := len(postorder) - 1; i >= 0; i-- {
-			x := postorder[i]
-			// get list of incoming edges
-			redges = redges[:0]
-			if ref1[x] != read.ObjNil {
-				redges = append(redges, ref1[x])
-				redges = append(redges, ref2[x]...)
-			}
-			a := read.ObjNil
-			for _, b := range redges {
-				if idom[b] == read.ObjNil {
-					continue
-				}
-				if a == read.ObjNil {
-					a = b
-					continue
-				}
-				for a != b {
-					if postnum[a] < postnum[b] {
-						a = idom[a]
-					} else {
-						b = idom[b]
-					}
-				}
-			}
-			if _, ok := roots[x]; ok {
-				a = read.ObjId(n)
-			}
-			if a != idom[x] {
-				idom[x] = a
-				change = true
-			}
-		}
-	}
-
-	domsize = make([]uint64, n+1)
-	for _, x := range postorder {
-		domsize[x] += d.Size(x)
-		domsize[idom[x]] += domsize[x]
-	}
-	// Note: unreachable objects will have domsize of 0.
-}
-
-func readPtr(b []byte) uint64 {
-	switch d.PtrSize {
-	case 4:
-		return uint64(d.Order.Uint32(b))
-	case 8:
-		return d.Order.Uint64(b)
-	default:
-		log.Fatal("unsupported PtrSize=%d", d.PtrSize)
-		return 0
-	}
+	d.RetainedSize(0)
 }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/randall77/hprof/read"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDump starts a little-endian, 64-bit dump of a heap at
+// [heapStart,heapEnd).
+func newTestDump(heapStart, heapEnd uint64) *read.TestDump {
+	w := read.NewTestDump(binary.LittleEndian, 8)
+	w.Header(heapStart, heapEnd)
+	return w
+}
+
+// rootObject writes a zeroed object of size bytes which is a root.
+func rootObject(w *read.TestDump, addr, typaddr, size uint64) {
+	w.Object(addr, typaddr, read.TypeKindObject, make([]byte, size))
+	w.OtherRoot("root", addr)
+}
+
+// hugeRootObject writes a pointer-free object of size bytes which is a
+// root, leaving its contents as a hole in the file.
+func hugeRootObject(w *read.TestDump, addr, size uint64) {
+	w.HugeObject(addr, size)
+	w.OtherRoot("root", addr)
+}
+
+// readDump ends the dump, writes it to a file and reads it.
+func readDump(t *testing.T, w *read.TestDump) *read.Dump {
+	w.End()
+	name := filepath.Join(t.TempDir(), "dump")
+	if err := w.WriteFile(name); err != nil {
+		t.Fatal(err)
+	}
+	d, err := read.Read(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestHugeTypeHistogram(t *testing.T) {
+	const (
+		heapStart = 0x10000
+		size      = 3 << 29 // 1.5GB
+		n         = 3
+	)
+	w := newTestDump(heapStart, heapStart+n*size)
+	for i := uint64(0); i < n; i++ {
+		hugeRootObject(w, heapStart+i*size, size)
+	}
+	d := readDump(t, w)
+
+	v := newViewer(0, "dump", d)
+	const want = n * size
+	if v.total != want {
+		t.Errorf("total = %d, want %d", v.total, want)
+	}
+	ft := d.Ft(0)
+	if b := v.byType[ft.Id]; b.bytes != want || len(b.objects) != n {
+		t.Errorf("byType[%s] = %d bytes in %d objects, want %d bytes in %d", ft.Name, b.bytes, len(b.objects), uint64(want), n)
+	}
+	if p := v.percent(v.byType[ft.Id].bytes); p != "100.0%" {
+		t.Errorf("percent of heap = %s, want 100.0%%", p)
+	}
+}
+
+func TestHistogramDelta(t *testing.T) {
+	const h = 0x10000
+
+	// main.T instances of two sizes are two full types of one name.
+	w := newTestDump(h, h+0x1000)
+	w.Type(0x100, 16, "main.T")
+	rootObject(w, h, 0x100, 16)
+	rootObject(w, h+16, 0x100, 32)
+	rootObject(w, h+48, 0, 16)
+	base := newViewer(0, "base", readDump(t, w))
+
+	w = newTestDump(h, h+0x1000)
+	w.Type(0x100, 16, "main.T")
+	rootObject(w, h, 0x100, 16)
+	rootObject(w, h+16, 0, 1<<10)
+	v := newViewer(1, "new", readDump(t, w))
+	v.base = base
+
+	want := map[string]string{
+		"main.T":    "▼-32B",
+		"noptr16":   "▼-16B",
+		"noptr1024": "▲+1KB",
+	}
+	rows := v.histogram()
+	if len(rows) != len(want) {
+		t.Errorf("got %d rows, want %d", len(rows), len(want))
+	}
+	for _, e := range rows {
+		// the name is a link to the type
+		name := string(e.Name)
+		name = name[strings.Index(name, ">")+1 : strings.LastIndex(name, "<")]
+		if e.Delta != want[name] {
+			t.Errorf("%s: delta %q, want %q", name, e.Delta, want[name])
+		}
+		if name == "noptr16" && (e.Count != 0 || !strings.Contains(string(e.Name), "dump=0")) {
+			t.Errorf("noptr16: count %d, link %s; want 0 and a link to the base dump", e.Count, e.Name)
+		}
+	}
+}
+
+func TestTruncateFields(t *testing.T) {
+	ptrs := []read.Field{{Kind: read.FieldKindPtr, Offset: 0}, {Kind: read.FieldKindPtr, Offset: 8}, {Kind: read.FieldKindPtr, Offset: 16}}
+	slice := []read.Field{{Kind: read.FieldKindSlice, Offset: 0}}
+	for _, tt := range []struct {
+		size   uint64
+		fields []read.Field
+		max    uint64
+		n      uint64
+		nfield int
+		cut    bool
+	}{
+		{24, ptrs, 24, 24, 3, false},
+		{24, ptrs, 8, 8, 1, true},
+		{24, ptrs, 12, 16, 2, true},
+		{1 << 30, nil, 100, 100, 0, true},
+		{1 << 30, ptrs, 100, 100, 3, true},
+		{1 << 30, slice, 8, 24, 1, true},
+		{24, slice, 8, 24, 1, false},
+	} {
+		n, fields, cut := truncateFields(tt.size, tt.fields, tt.max)
+		if n != tt.n || len(fields) != tt.nfield || cut != tt.cut {
+			t.Errorf("truncateFields(%d, %d fields, %d) = %d, %d fields, %v; want %d, %d fields, %v",
+				tt.size, len(tt.fields), tt.max, n, len(fields), cut, tt.n, tt.nfield, tt.cut)
+		}
+	}
+}
+
+// TestObjHandlerHuge checks that the page for a huge object reads
+// only the part of it which is shown.
+func TestObjHandlerHuge(t *testing.T) {
+	const (
+		heapStart = 0x10000
+		size      = 4 << 30
+	)
+	w := newTestDump(heapStart, heapStart+size)
+	hugeRootObject(w, heapStart, size)
+	v := newViewer(0, "dump", readDump(t, w))
+	defer func(n int) { *maxFields = n }(*maxFields)
+	*maxFields = 1 << 20
+
+	rec := httptest.NewRecorder()
+	v.objHandler(rec, httptest.NewRequest("GET", "/obj?dump=0&id=0", nil))
+	if rec.Code != 200 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	for _, want := range []string{
+		fmt.Sprintf("%d of %d bytes shown", *maxBytes, uint64(size)),
+		fmt.Sprintf("... %d elided bytes ...", size-*maxBytes),
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("page doesn't say %q:\n%s", want, rec.Body)
+		}
+	}
+	if strings.Contains(rec.Body.String(), "sizeclass pad") {
+		t.Errorf("unshown bytes labeled as padding:\n%s", rec.Body)
+	}
+}
+
+// fakeDot puts a dot command running script first in $PATH.
+func fakeDot(t *testing.T, script string) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "dot"), []byte("#!/bin/sh\n"+script+"\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestGraphHandler(t *testing.T) {
+	const h = 0x10000
+	w := newTestDump(h, h+0x1000)
+	rootObject(w, h, 0, 16)
+	s := &server{viewers: []*viewer{newViewer(0, "dump", readDump(t, w))}, work: make(chan bool, 1)}
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.graphHandler(rec, httptest.NewRequest("GET", "/graph?dump=0&id=0", nil))
+		return rec
+	}
+
+	fakeDot(t, "cat")
+	rec := get()
+	if rec.Code != 200 || !strings.HasPrefix(rec.Body.String(), "digraph") {
+		t.Errorf("got status %d and %q, want the graph source", rec.Code, rec.Body)
+	}
+
+	defer func(d time.Duration) { *dotTimeout = d }(*dotTimeout)
+	*dotTimeout = 100 * time.Millisecond
+	fakeDot(t, "exec sleep 60")
+	start := time.Now()
+	rec = get()
+	if rec.Code != 503 {
+		t.Errorf("slow graphviz: got status %d, want 503", rec.Code)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("slow graphviz ran for %v", d)
+	}
+}
+
+func TestUnknownFieldKindLoggedOnce(t *testing.T) {
+	const h = 0x10000
+	w := newTestDump(h, h+0x1000)
+	rootObject(w, h, 0, 16)
+	v := newViewer(0, "dump", readDump(t, w))
+
+	var logged bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logged)
+	fields := []read.Field{{Kind: 99, Offset: 0, Name: "x"}, {Kind: 98, Offset: 8, Name: "y"}}
+	for i := 0; i < 3; i++ {
+		fld := v.getFields(make([]byte, 16), 16, fields, nil)
+		if len(fld) != 2 || fld[0].Typ != "unknown kind 99" {
+			t.Fatalf("got fields %v, want two of unknown kinds", fld)
+		}
+	}
+	if n := strings.Count(logged.String(), "unknown field kind"); n != 2 {
+		t.Errorf("logged %d unknown field kinds, want 2:\n%s", n, logged.String())
+	}
+}
+
+func TestStringsHandlerCached(t *testing.T) {
+	const h = 0x10000
+	w := newTestDump(h, h+0x1000)
+	rootObject(w, h, 0, 16)
+	v := newViewer(0, "dump", readDump(t, w))
+
+	get := func() string {
+		rec := httptest.NewRecorder()
+		v.stringsHandler(rec, httptest.NewRequest("GET", "/strings", nil))
+		return rec.Body.String()
+	}
+	first := get()
+	info := v.dupStrings
+	if info == nil {
+		t.Fatal("strings page not saved")
+	}
+	if second := get(); second != first || v.dupStrings != info {
+		t.Errorf("second strings page was recomputed or differs")
+	}
+}
+
+func TestLocalReferer(t *testing.T) {
+	for _, tt := range []struct {
+		referer, want string
+	}{
+		{"", "/pinned"},
+		{"http://example.com/obj?dump=0&id=3", "/obj?dump=0&id=3"},
+		{"http://example.com/", "/"},
+		{"http://evil.com/obj", "/pinned"},
+		{"//evil.com/obj", "/pinned"},
+		{"http://example.com//evil.com/", "/pinned"},
+		{"javascript:alert(1)", "/pinned"},
+	} {
+		r := httptest.NewRequest("GET", "/pin?dump=0&id=0", nil)
+		r.Header.Set("Referer", tt.referer)
+		if got := localReferer(r, "/pinned"); got != tt.want {
+			t.Errorf("localReferer with Referer %q = %q, want %q", tt.referer, got, tt.want)
+		}
+	}
+}
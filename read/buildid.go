@@ -0,0 +1,105 @@
+package read
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+// buildIDMarker precedes the quoted build ID that the Go linker
+// writes near the start of the text of non-ELF executables.
+var buildIDMarker = []byte("\xff Go build ID: \"")
+
+// readBuildID returns the Go build ID of the executable, or "" if it
+// has none.
+func readBuildID(execname string) string {
+	if e, err := elf.Open(execname); err == nil {
+		defer e.Close()
+		s := e.Section(".note.go.buildid")
+		if s == nil {
+			return ""
+		}
+		b, err := s.Data()
+		if err != nil || len(b) < 16 {
+			return ""
+		}
+		// a single note: namesz, descsz, type, name "Go\0\0", desc
+		namesz := e.ByteOrder.Uint32(b)
+		descsz := e.ByteOrder.Uint32(b[4:])
+		off := 12 + (uint64(namesz)+3)&^3
+		if off+uint64(descsz) > uint64(len(b)) {
+			return ""
+		}
+		return string(b[off : off+uint64(descsz)])
+	}
+
+	f, err := os.Open(execname)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	b := make([]byte, 32<<10)
+	n, err := io.ReadFull(f, b)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ""
+	}
+	b = b[:n]
+	i := bytes.Index(b, buildIDMarker)
+	if i < 0 {
+		return ""
+	}
+	b = b[i+len(buildIDMarker)-1:]
+	j := bytes.IndexByte(b[1:], '"')
+	if j < 0 {
+		return ""
+	}
+	id, err := strconv.Unquote(string(b[:j+2]))
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
+// dataSection returns the address and size of the executable's data
+// section, which should be where the dump's Data record is.  pie
+// reports whether the executable is position independent, in which
+// case the section is relocated when it is loaded.
+func dataSection(execname string) (addr, size uint64, pie, ok bool) {
+	if e, err := elf.Open(execname); err == nil {
+		defer e.Close()
+		if s := e.Section(".data"); s != nil {
+			return s.Addr, s.Size, e.Type == elf.ET_DYN, true
+		}
+		return 0, 0, false, false
+	}
+	if m, err := macho.Open(execname); err == nil {
+		defer m.Close()
+		if s := m.Section("__data"); s != nil {
+			return s.Addr, s.Size, m.Flags&macho.FlagPIE != 0, true
+		}
+	}
+	return 0, 0, false, false
+}
+
+// checkExecutable warns if the executable evidently isn't the one
+// that wrote the dump.  The dump carries no build ID, so the location
+// of the data section is compared instead.  Only its size is compared
+// for position independent executables, which are relocated.
+func checkExecutable(d *Dump, execname string) {
+	d.BuildID = readBuildID(execname)
+	addr, size, pie, ok := dataSection(execname)
+	if !ok || d.Data == nil || d.Data.Addr == 0 {
+		return
+	}
+	if pie {
+		addr = d.Data.Addr
+	}
+	if addr != d.Data.Addr || size != uint64(len(d.Data.Data)) {
+		log.Printf("WARNING: %s (build ID %q) did not write this dump: its data section is at %#x, size %d; the dump's is at %#x, size %d.  Field names will be wrong.",
+			execname, d.BuildID, addr, size, d.Data.Addr, len(d.Data.Data))
+	}
+}
@@ -0,0 +1,82 @@
+package read
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DebugDir is the global debug directory searched for the separate
+// debug info file named by an executable's .gnu_debuglink section.
+var DebugDir = "/usr/lib/debug"
+
+// debugLinkDwarf returns the DWARF info in the separate debug file that
+// the ELF executable e, read from execname, names in its
+// .gnu_debuglink section.  Like gdb, it looks for the file next to the
+// executable, in its .debug subdirectory, and under DebugDir, and
+// checks the file's CRC.  It returns nil if there is no such file.
+func debugLinkDwarf(e *elf.File, execname string) *dwarf.Data {
+	s := e.Section(".gnu_debuglink")
+	if s == nil {
+		return nil
+	}
+	b, err := s.Data()
+	if err != nil {
+		return nil
+	}
+	// NUL-terminated file name, padded to 4 bytes, then the CRC
+	i := bytes.IndexByte(b, 0)
+	if i <= 0 {
+		return nil
+	}
+	name := string(b[:i])
+	off := (i + 4) &^ 3
+	if off+4 > len(b) {
+		return nil
+	}
+	crc := e.ByteOrder.Uint32(b[off:])
+
+	dir := filepath.Dir(execname)
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	for _, path := range []string{
+		filepath.Join(dir, name),
+		filepath.Join(dir, ".debug", name),
+		filepath.Join(DebugDir, dir, name),
+	} {
+		if c, err := fileCRC(path); err != nil || c != crc {
+			continue
+		}
+		f, err := elf.Open(path)
+		if err != nil {
+			continue
+		}
+		d, err := f.DWARF()
+		f.Close()
+		if err != nil {
+			continue
+		}
+		return d
+	}
+	return nil
+}
+
+// fileCRC returns the IEEE CRC-32 of the named file, without holding
+// all of it in memory.
+func fileCRC(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
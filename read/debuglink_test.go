@@ -0,0 +1,69 @@
+package read
+
+import (
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestDebugLinkDwarf(t *testing.T) {
+	objcopy, err := exec.LookPath("objcopy")
+	if err != nil {
+		t.Skip("no objcopy")
+	}
+	gotool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command")
+	}
+
+	// Build a small executable, then split it into a stripped
+	// executable and a debug file next to it.
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() {}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	full := filepath.Join(dir, "full")
+	exe := filepath.Join(dir, "exe")
+	debug := filepath.Join(dir, "exe.debug")
+	cmd := exec.Command(gotool, "build", "-o", full, src)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	for _, args := range [][]string{
+		{"--only-keep-debug", full, debug},
+		{"--strip-debug", "--add-gnu-debuglink=" + debug, full, exe},
+	} {
+		if out, err := exec.Command(objcopy, args...).CombinedOutput(); err != nil {
+			t.Skipf("objcopy %v: %v\n%s", args, err, out)
+		}
+	}
+
+	e, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if _, err := e.DWARF(); err == nil {
+		t.Skip("objcopy left the DWARF info in the executable")
+	}
+	if debugLinkDwarf(e, exe) == nil {
+		t.Errorf("no DWARF info found through the debug link")
+	}
+
+	// A debug file whose CRC doesn't match isn't used.
+	f, err := os.OpenFile(debug, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0})
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if debugLinkDwarf(e, exe) != nil {
+		t.Errorf("DWARF info found in a debug file with the wrong CRC")
+	}
+}
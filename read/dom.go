@@ -0,0 +1,583 @@
+package read
+
+import (
+	"log"
+	"regexp"
+	"sort"
+)
+
+// Referrers returns the distinct objects which have at least one edge
+// to object x.  The result must not be modified.
+func (d *Dump) Referrers(x ObjId) []ObjId {
+	d.referrers()
+	y := d.ref1[x]
+	if y == ObjNil {
+		return nil
+	}
+	if s, ok := d.ref2[x]; ok {
+		return s
+	}
+	return d.ref1[x : x+1]
+}
+
+// referrers computes the referrer index, if it hasn't been already.
+func (d *Dump) referrers() {
+	if d.ref1 != nil {
+		return
+	}
+	n := d.NumObjects()
+	ref1 := make([]ObjId, n)
+	for i := 0; i < n; i++ {
+		ref1[i] = ObjNil
+	}
+	ref2 := map[ObjId][]ObjId{}
+	for i := 0; i < n; i++ {
+		x := ObjId(i)
+		for _, e := range d.Edges(x) {
+			r := ref1[e.To]
+			if r == ObjNil {
+				ref1[e.To] = x
+			} else if x != r {
+				s := ref2[e.To]
+				if len(s) == 0 {
+					s = append(s, r)
+				}
+				if x != s[len(s)-1] {
+					ref2[e.To] = append(s, x)
+				}
+			}
+		}
+	}
+	d.ref1 = ref1
+	d.ref2 = ref2
+}
+
+// Idom returns the immediate dominator of object x.  It returns ObjNil
+// if x is unreachable or if no single object dominates it, that is, if
+// it is dominated only by the roots.
+func (d *Dump) Idom(x ObjId) ObjId {
+	d.dom()
+	y := d.idom[x]
+	if y == ObjId(d.NumObjects()) {
+		return ObjNil
+	}
+	return y
+}
+
+// RetainedSize returns the number of bytes in the heap that are
+// dominated by object x, including x itself.  This is the amount of
+// memory that would be freed if x were.  Unreachable objects retain 0
+// bytes.
+func (d *Dump) RetainedSize(x ObjId) uint64 {
+	d.dom()
+	return d.domsize[x]
+}
+
+// Hogs returns up to n objects with the largest retained sizes, in
+// decreasing order of retained size, such that none of them is
+// dominated by another.  These are the objects that hold onto the most
+// memory independently of each other.
+func (d *Dump) Hogs(n int) []ObjId {
+	d.domTree()
+	var objs []ObjId
+	for i := 0; i < d.NumObjects(); i++ {
+		if d.domsize[i] > 0 {
+			objs = append(objs, ObjId(i))
+		}
+	}
+	sort.Sort(byRetained{d, objs})
+
+	// An object's dominators retain at least as much as it does, so
+	// they are considered before it is.  Picking an object covers
+	// the subtree it dominates.  Those subtrees don't overlap, so
+	// each object is covered at most once.
+	var hogs []ObjId
+	covered := make([]bool, d.NumObjects())
+	var s []ObjId
+	for _, x := range objs {
+		if len(hogs) == n {
+			break
+		}
+		if covered[x] {
+			continue
+		}
+		hogs = append(hogs, x)
+		s = append(s[:0], x)
+		for len(s) > 0 {
+			y := s[len(s)-1]
+			s = s[:len(s)-1]
+			covered[y] = true
+			s = append(s, d.kids[d.kidIdx[y]:d.kidIdx[y+1]]...)
+		}
+	}
+	return hogs
+}
+
+// RetainedSet returns the objects dominated by x, that is, the objects
+// that would be freed if x were, including x itself.  Unreachable
+// objects retain nothing, so the result is empty for them.
+func (d *Dump) RetainedSet(x ObjId) []ObjId {
+	d.domTree()
+	if d.idom[x] == ObjNil {
+		return nil
+	}
+	s := []ObjId{x}
+	for i := 0; i < len(s); i++ {
+		y := s[i]
+		s = append(s, d.kids[d.kidIdx[y]:d.kidIdx[y+1]]...)
+	}
+	return s
+}
+
+// Dominated returns the objects whose immediate dominator is x, its
+// children in the dominator tree.  If x is ObjNil it returns the
+// objects dominated only by the roots, the top level of the tree.  The
+// result must not be modified.
+func (d *Dump) Dominated(x ObjId) []ObjId {
+	d.domTree()
+	if x == ObjNil {
+		x = ObjId(d.NumObjects())
+	}
+	return d.kids[d.kidIdx[x]:d.kidIdx[x+1]]
+}
+
+// domTree computes the children lists of the dominator tree, if they
+// haven't been already.
+func (d *Dump) domTree() {
+	if d.kidIdx != nil {
+		return
+	}
+	d.dom()
+	n := d.NumObjects()
+	kidIdx := make([]int, n+2)
+	for i := 0; i < n; i++ {
+		if p := d.idom[i]; p != ObjNil {
+			kidIdx[p+1]++
+		}
+	}
+	for i := 1; i < len(kidIdx); i++ {
+		kidIdx[i] += kidIdx[i-1]
+	}
+	kids := make([]ObjId, kidIdx[n+1])
+	next := make([]int, n+1)
+	copy(next, kidIdx)
+	for i := 0; i < n; i++ {
+		if p := d.idom[i]; p != ObjNil {
+			kids[next[p]] = ObjId(i)
+			next[p]++
+		}
+	}
+	d.kids = kids
+	d.kidIdx = kidIdx
+
+	// number the nodes of the tree
+	pre := make([]int, n+1)
+	post := make([]int, n+1)
+	clock := 0
+	type frame struct {
+		x    ObjId
+		next int // index in kids of the next child to visit
+	}
+	stack := []frame{{ObjId(n), kidIdx[n]}}
+	pre[n] = clock
+	clock++
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		if f.next == kidIdx[f.x+1] {
+			post[f.x] = clock
+			clock++
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		y := kids[f.next]
+		f.next++
+		pre[y] = clock
+		clock++
+		stack = append(stack, frame{y, kidIdx[y]})
+	}
+	d.pre = pre
+	d.post = post
+}
+
+// RetainedByType returns, indexed by FullType id, the number of bytes
+// retained by all the instances of each type together.  That is the
+// sum of the retained sizes of the instances that aren't dominated by
+// another instance of the same type.
+func (d *Dump) RetainedByType() []uint64 {
+	d.domTree()
+	n := d.NumObjects()
+	r := make([]uint64, len(d.FTList))
+	// active[t] is the number of instances of type t on the path from
+	// the root to the current node of the dominator tree.
+	active := make([]int, len(d.FTList))
+	type frame struct {
+		x    ObjId
+		next int // index in kids of the next child to visit
+	}
+	stack := []frame{{ObjId(n), d.kidIdx[n]}}
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		if f.next == d.kidIdx[f.x+1] {
+			if f.x != ObjId(n) {
+				active[d.objFt[f.x]]--
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		y := d.kids[f.next]
+		f.next++
+		t := d.objFt[y]
+		if active[t] == 0 {
+			r[t] += d.domsize[y]
+		}
+		active[t]++
+		stack = append(stack, frame{y, d.kidIdx[y]})
+	}
+	return r
+}
+
+type byRetained struct {
+	d    *Dump
+	objs []ObjId
+}
+
+func (a byRetained) Len() int      { return len(a.objs) }
+func (a byRetained) Swap(i, j int) { a.objs[i], a.objs[j] = a.objs[j], a.objs[i] }
+func (a byRetained) Less(i, j int) bool {
+	x, y := a.objs[i], a.objs[j]
+	if a.d.domsize[x] != a.d.domsize[y] {
+		return a.d.domsize[x] > a.d.domsize[y]
+	}
+	// dominators first
+	return a.d.pre[x] < a.d.pre[y]
+}
+
+// dom computes the dominator tree of the object graph and the size of
+// the heap dominated by each object, if they haven't been already.
+//
+// The graph's nodes are whole objects: an edge is keyed on the object
+// containing its target (Edge.To), not on the address it lands at.  So
+// an interior pointer, such as a slice into the middle of a backing
+// array, keeps the entire object alive and counts toward dominating it
+// exactly like a pointer to its head.  An object whose only inbound
+// edges are interior pointers is dominated just as if they were head
+// pointers, and several slices sharing one backing array all count as
+// predecessors of that array, so none of them alone dominates it.
+func (d *Dump) dom() {
+	if d.idom != nil {
+		return
+	}
+	d.referrers()
+	n := d.NumObjects()
+
+	// make list of roots
+	roots := map[ObjId]struct{}{}
+	for _, s := range []*Data{d.Data, d.Bss} {
+		for _, e := range s.Edges {
+			roots[e.To] = struct{}{}
+		}
+	}
+	for _, f := range d.Frames {
+		for _, e := range f.Edges {
+			roots[e.To] = struct{}{}
+		}
+	}
+	for _, x := range d.Otherroots {
+		for _, e := range x.Edges {
+			roots[e.To] = struct{}{}
+		}
+	}
+	for _, f := range d.QFinal {
+		for _, e := range f.Edges {
+			roots[e.To] = struct{}{}
+		}
+	}
+	for _, g := range d.Goroutines {
+		if g.Ctxt != ObjNil {
+			roots[g.Ctxt] = struct{}{}
+		}
+	}
+
+	// compute postorder traversal
+	// object states:
+	// 0 - not seen yet
+	// 1 - seen, added to queue, not yet expanded children
+	// 2 - seen, already expanded children
+	// 3 - added to postorder
+	postorder := make([]ObjId, 0, n)
+	postnum := make([]int, n+1)
+	state := make([]byte, n)
+	var q []ObjId // stack of work to do, holds state 1 and 2 objects
+	for x := range roots {
+		if state[x] != 0 {
+			if state[x] != 3 {
+				log.Fatal("bad state found")
+			}
+			continue
+		}
+		state[x] = 1
+		q = q[:0]
+		q = append(q, x)
+		for len(q) > 0 {
+			y := q[len(q)-1]
+			if state[y] == 2 {
+				state[y] = 3
+				q = q[:len(q)-1]
+				postnum[y] = len(postorder)
+				postorder = append(postorder, y)
+			} else {
+				if state[y] != 1 {
+					log.Fatal("bad state")
+				}
+				state[y] = 2
+				for _, e := range d.Edges(y) {
+					z := e.To
+					if state[z] == 0 {
+						state[z] = 1
+						q = append(q, z)
+					}
+				}
+			}
+		}
+	}
+	postnum[n] = n // virtual start node
+
+	// compute immediate dominators
+	// http://www.hipersoft.rice.edu/grads/publications/dom14.pdf
+	idom := make([]ObjId, n+1)
+	for i := 0; i < n; i++ {
+		idom[i] = ObjNil
+	}
+	idom[n] = ObjId(n)
+	for r := range roots {
+		idom[r] = ObjId(n)
+	}
+	change := true
+	for change {
+		change = false
+		for i := len(postorder) - 1; i >= 0; i-- {
+			x := postorder[i]
+			a := ObjNil
+			for _, b := range d.Referrers(x) {
+				if idom[b] == ObjNil {
+					continue
+				}
+				if a == ObjNil {
+					a = b
+					continue
+				}
+				for a != b {
+					if postnum[a] < postnum[b] {
+						a = idom[a]
+					} else {
+						b = idom[b]
+					}
+				}
+			}
+			if _, ok := roots[x]; ok {
+				a = ObjId(n)
+			}
+			if a != idom[x] {
+				idom[x] = a
+				change = true
+			}
+		}
+	}
+
+	domsize := make([]uint64, n+1)
+	for _, x := range postorder {
+		domsize[x] += d.Size(x)
+		domsize[idom[x]] += domsize[x]
+	}
+	// Note: unreachable objects will have domsize of 0.
+
+	d.idom = idom
+	d.domsize = domsize
+}
+
+// Root categories reported by RetainedByRoot.
+const (
+	RootStacks     = "goroutine stacks"
+	RootGlobals    = "globals"
+	RootOther      = "other roots"
+	RootFinalizers = "finalizers"
+	RootShared     = "shared"
+)
+
+// RootObjects returns the objects pointed to directly by the roots of
+// the given category, one of RootStacks, RootGlobals, RootOther and
+// RootFinalizers.  An object may appear more than once.
+func (d *Dump) RootObjects(kind string) []ObjId {
+	var r []ObjId
+	switch kind {
+	case RootStacks:
+		for _, f := range d.Frames {
+			for _, e := range f.Edges {
+				r = append(r, e.To)
+			}
+		}
+		for _, g := range d.Goroutines {
+			if g.Ctxt != ObjNil {
+				r = append(r, g.Ctxt)
+			}
+		}
+	case RootGlobals:
+		for _, x := range []*Data{d.Data, d.Bss} {
+			for _, e := range x.Edges {
+				r = append(r, e.To)
+			}
+		}
+	case RootOther:
+		for _, x := range d.Otherroots {
+			for _, e := range x.Edges {
+				r = append(r, e.To)
+			}
+		}
+	case RootFinalizers:
+		for _, f := range d.QFinal {
+			for _, e := range f.Edges {
+				r = append(r, e.To)
+			}
+		}
+	}
+	return r
+}
+
+// RetainedByRoot partitions the live heap by the category of root
+// that retains it.  Each subtree of the dominator tree hanging directly
+// off the roots is attributed to the one category of root it is
+// reachable from, or to RootShared if it is reachable from several.
+func (d *Dump) RetainedByRoot() map[string]uint64 {
+	d.dom()
+	n := d.NumObjects()
+
+	// from[x] has bit i set if x is reachable from root category i.
+	from := make([]uint8, n)
+	for i, kind := range []string{RootStacks, RootGlobals, RootOther, RootFinalizers} {
+		bit := uint8(1) << uint(i)
+		q := d.RootObjects(kind)
+		for _, x := range q {
+			from[x] |= bit
+		}
+		for len(q) > 0 {
+			x := q[len(q)-1]
+			q = q[:len(q)-1]
+			for _, e := range d.Edges(x) {
+				if from[e.To]&bit == 0 {
+					from[e.To] |= bit
+					q = append(q, e.To)
+				}
+			}
+		}
+	}
+
+	names := map[uint8]string{1: RootStacks, 2: RootGlobals, 4: RootOther, 8: RootFinalizers}
+	r := map[string]uint64{}
+	for i := 0; i < n; i++ {
+		if d.idom[i] != ObjId(n) {
+			continue // dominated by another object, or unreachable
+		}
+		name, ok := names[from[i]]
+		if !ok {
+			name = RootShared
+		}
+		r[name] += d.domsize[i]
+	}
+	return r
+}
+
+// poolType matches the names of the types sync.Pool keeps its cached
+// objects in.
+var poolType = regexp.MustCompile(`sync\.(Pool|poolLocal)\b`)
+
+// PoolRetained returns the number of objects and bytes retained only
+// through sync.Pool internals.  They are live but logically free, so
+// they can be subtracted from the live heap to get the working set.
+func (d *Dump) PoolRetained() (objects int, bytes uint64) {
+	d.dom()
+	n := d.NumObjects()
+	isPool := make([]bool, len(d.FTList))
+	for _, ft := range d.FTList {
+		isPool[ft.Id] = poolType.MatchString(ft.Name)
+	}
+	// under[x] is 1 if x or one of its dominators is a pool object,
+	// 2 if not, and 0 if we don't know yet.
+	under := make([]byte, n+1)
+	under[n] = 2
+	var chain []ObjId
+	for i := 0; i < n; i++ {
+		x := ObjId(i)
+		if d.domsize[x] == 0 {
+			continue
+		}
+		chain = chain[:0]
+		y := d.idom[x]
+		for under[y] == 0 && !isPool[d.objFt[y]] {
+			chain = append(chain, y)
+			y = d.idom[y]
+		}
+		u := under[y]
+		if u == 0 {
+			u = 1 // y is a pool object
+		}
+		for _, z := range chain {
+			under[z] = u
+		}
+		if isPool[d.objFt[x]] {
+			under[x] = 1
+		} else {
+			under[x] = u
+			if u == 1 {
+				objects++
+				bytes += d.Size(x)
+			}
+		}
+	}
+	return objects, bytes
+}
+
+// RetainedIfCut returns the number of bytes that would become
+// unreachable if the references from object from to object to were
+// removed.  That is all of to's retained size if from is the only
+// object keeping to alive, and nothing otherwise.  References from
+// objects that to itself retains don't keep it alive.
+func (d *Dump) RetainedIfCut(from, to ObjId) uint64 {
+	if from == ObjNil || d.SoleHolder(to) != from {
+		return 0
+	}
+	return d.RetainedSize(to)
+}
+
+// SoleHolder returns the only object keeping x alive: the one live
+// referrer of x that x doesn't itself retain.  It returns ObjNil if x
+// is unreachable, held by a root, or held by several such objects.
+func (d *Dump) SoleHolder(x ObjId) ObjId {
+	if d.Idom(x) == ObjNil {
+		return ObjNil
+	}
+	reachable := d.Reachable()
+	holder := ObjNil
+	for _, y := range d.Referrers(x) {
+		if !reachable[y] || d.dominates(x, y) {
+			continue
+		}
+		if holder != ObjNil {
+			return ObjNil
+		}
+		holder = y
+	}
+	return holder
+}
+
+// dominates reports whether object x dominates object y, that is,
+// whether every path from the roots to y goes through x.
+func (d *Dump) dominates(x, y ObjId) bool {
+	if x == y {
+		return true
+	}
+	d.domTree()
+	if d.idom[x] == ObjNil || d.idom[y] == ObjNil {
+		return false
+	}
+	return d.pre[x] <= d.pre[y] && d.post[y] <= d.post[x]
+}
@@ -0,0 +1,204 @@
+package read
+
+import (
+	"bytes"
+	"testing"
+)
+
+var domTests = []struct {
+	name     string
+	edges    [][]int
+	roots    []int
+	idom     []int // -1 for objects dominated only by the roots, or unreachable
+	retained []uint64
+}{
+	{
+		name:     "diamond",
+		edges:    [][]int{{1, 2}, {3}, {3}, {}},
+		roots:    []int{0},
+		idom:     []int{-1, 0, 0, 0},
+		retained: []uint64{128, 32, 32, 32},
+	},
+	{
+		// an inner loop 2->3->2 inside an outer loop 1->2->3->1,
+		// both of which can be entered from 0
+		name:     "nested loops",
+		edges:    [][]int{{1, 2}, {2}, {3}, {1, 2, 4}, {}},
+		roots:    []int{0},
+		idom:     []int{-1, 0, 0, 2, 3},
+		retained: []uint64{160, 32, 96, 64, 32},
+	},
+	{
+		// 2 is held by both roots, and 1 is a root held by 0
+		name:     "multiple roots",
+		edges:    [][]int{{1, 2}, {2, 4}, {3}, {}, {}},
+		roots:    []int{0, 1},
+		idom:     []int{-1, -1, -1, 2, 1},
+		retained: []uint64{32, 64, 64, 32, 32},
+	},
+	{
+		// 2 and 3 are garbage, though 2 points into the live heap
+		name:     "unreachable",
+		edges:    [][]int{{1}, {}, {1, 3}, {}},
+		roots:    []int{0},
+		idom:     []int{0: -1, 1: 0, 2: -1, 3: -1},
+		retained: []uint64{64, 32, 0, 0},
+	},
+	{
+		// The paths to 4 meet only at the virtual root node, so
+		// intersecting them has to walk both up to it.  That works
+		// only if the virtual node is numbered after every object.
+		name:     "virtual root",
+		edges:    [][]int{{2}, {3}, {4}, {4}, {}},
+		roots:    []int{0, 1},
+		idom:     []int{-1, -1, 0, 1, -1},
+		retained: []uint64{64, 64, 32, 32, 32},
+	},
+}
+
+func TestDominators(t *testing.T) {
+	for _, test := range domTests {
+		d := graphDump(t, test.edges, test.roots...)
+		n := d.NumObjects()
+		if n != len(test.edges) {
+			t.Fatalf("%s: got %d objects, want %d", test.name, n, len(test.edges))
+		}
+		var live uint64
+		for i := 0; i < n; i++ {
+			x := ObjId(i)
+			want := ObjNil
+			if test.idom[i] >= 0 {
+				want = ObjId(test.idom[i])
+			}
+			if got := d.Idom(x); got != want {
+				t.Errorf("%s: Idom(%d) = %d, want %d", test.name, x, got, want)
+			}
+			if d.Live(x) {
+				live += d.Size(x)
+				if got := d.idom[x]; want == ObjNil && got != ObjId(n) {
+					t.Errorf("%s: idom[%d] = %d, want the virtual root %d", test.name, x, got, n)
+				}
+			} else if got := d.idom[x]; got != ObjNil {
+				t.Errorf("%s: idom[%d] = %d for an unreachable object, want ObjNil", test.name, x, got)
+			}
+			if got := d.RetainedSize(x); got != test.retained[i] {
+				t.Errorf("%s: RetainedSize(%d) = %d, want %d", test.name, x, got, test.retained[i])
+			}
+			if got := d.domsize[x]; got != test.retained[i] {
+				t.Errorf("%s: domsize[%d] = %d, want %d", test.name, x, got, test.retained[i])
+			}
+		}
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				x, y := ObjId(i), ObjId(j)
+				want := x == y
+				for z := d.Idom(y); z != ObjNil && !want; z = d.Idom(z) {
+					want = z == x
+				}
+				if got := d.dominates(x, y); got != want {
+					t.Errorf("%s: dominates(%d, %d) = %v, want %v", test.name, x, y, got, want)
+				}
+			}
+		}
+		if d.idom[n] != ObjId(n) {
+			t.Errorf("%s: idom of the virtual root = %d, want %d", test.name, d.idom[n], n)
+		}
+		if d.domsize[n] != live {
+			t.Errorf("%s: virtual root retains %d bytes, want all %d live bytes", test.name, d.domsize[n], live)
+		}
+	}
+}
+
+// TestInteriorPointerDominator checks that an object reached only
+// through pointers into its middle is dominated by the object holding
+// them, as if they pointed to its head.
+func TestInteriorPointerDominator(t *testing.T) {
+	const typAddr = 0x100
+	w := newTestDump()
+	w.Header(nodeAddr(0), nodeAddr(3))
+	w.Type(typAddr, nodeSize, "main.node", 0, 8, 16, 24)
+	// 0 points twice into the middle of 1, and 2 holds nothing
+	w.Object(nodeAddr(0), typAddr, TypeKindObject, w.Words(nodeAddr(1)+8, nodeAddr(1)+24, 0, 0))
+	w.Object(nodeAddr(1), typAddr, TypeKindObject, w.Words(0, 0, 0, 0))
+	w.Object(nodeAddr(2), typAddr, TypeKindObject, w.Words(0, 0, 0, 0))
+	w.OtherRoot("root", nodeAddr(0))
+	w.OtherRoot("root", nodeAddr(2))
+	w.End()
+	d, err := ReadFrom(bytes.NewReader(w.Bytes()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Idom(1); got != 0 {
+		t.Errorf("Idom(1) = %d, want 0", got)
+	}
+	if got := d.RetainedSize(0); got != 2*nodeSize {
+		t.Errorf("RetainedSize(0) = %d, want %d", got, 2*nodeSize)
+	}
+
+	// Once 2 also points into 1, neither holder dominates it.
+	w = newTestDump()
+	w.Header(nodeAddr(0), nodeAddr(3))
+	w.Type(typAddr, nodeSize, "main.node", 0, 8, 16, 24)
+	w.Object(nodeAddr(0), typAddr, TypeKindObject, w.Words(nodeAddr(1)+8, 0, 0, 0))
+	w.Object(nodeAddr(1), typAddr, TypeKindObject, w.Words(0, 0, 0, 0))
+	w.Object(nodeAddr(2), typAddr, TypeKindObject, w.Words(nodeAddr(1)+16, 0, 0, 0))
+	w.OtherRoot("root", nodeAddr(0))
+	w.OtherRoot("root", nodeAddr(2))
+	w.End()
+	d, err = ReadFrom(bytes.NewReader(w.Bytes()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Idom(1); got != ObjNil {
+		t.Errorf("shared: Idom(1) = %d, want ObjNil", got)
+	}
+	if got := d.RetainedSize(0); got != nodeSize {
+		t.Errorf("shared: RetainedSize(0) = %d, want %d", got, nodeSize)
+	}
+}
+
+func TestSoleHolder(t *testing.T) {
+	// a list whose nodes all point back to its head
+	d := graphDump(t, [][]int{{1}, {2, 0}, {3, 0}, {0}, {3}}, 0)
+	for x, want := range []ObjId{ObjNil, 0, 1, 2, ObjNil} {
+		if got := d.SoleHolder(ObjId(x)); got != want {
+			t.Errorf("SoleHolder(%d) = %d, want %d", x, got, want)
+		}
+	}
+	if got := d.RetainedIfCut(1, 2); got != 2*nodeSize {
+		t.Errorf("RetainedIfCut(1, 2) = %d, want %d", got, 2*nodeSize)
+	}
+	if got := d.RetainedIfCut(0, 2); got != 0 {
+		t.Errorf("RetainedIfCut(0, 2) = %d, want 0", got)
+	}
+
+	// two holders
+	d = graphDump(t, [][]int{{1, 2}, {3}, {3}, {}}, 0)
+	if got := d.SoleHolder(3); got != ObjNil {
+		t.Errorf("diamond: SoleHolder(3) = %d, want ObjNil", got)
+	}
+}
+
+func TestHogs(t *testing.T) {
+	// 1 and 2 retain 64 bytes each, 4 and 3 respectively nested in
+	// them, and 0 retains only itself.
+	d := graphDump(t, [][]int{{1, 2}, {2, 4}, {3}, {}, {}}, 0, 1)
+	h := d.Hogs(10)
+	if len(h) != 3 || h[0]+h[1] != 3 || h[2] != 0 {
+		t.Errorf("Hogs(10) = %v, want 1 and 2 in either order, then 0", h)
+	}
+	if h := d.Hogs(1); len(h) != 1 || d.RetainedSize(h[0]) != 64 {
+		t.Errorf("Hogs(1) = %v, want 1 or 2", h)
+	}
+
+	// a chain, each link retaining the rest
+	const n = 1000
+	edges := make([][]int, n)
+	for i := 0; i < n-1; i++ {
+		edges[i] = []int{i + 1}
+	}
+	d = graphDump(t, edges, 0)
+	if h := d.Hogs(n); len(h) != 1 || h[0] != 0 {
+		t.Errorf("chain: Hogs(%d) = %v, want [0]", n, h)
+	}
+}
@@ -0,0 +1,12 @@
+package read
+
+import "strings"
+
+// DotQuote returns s as a quoted string for a graphviz dot file.
+// Newlines in s become line breaks in the label.
+func DotQuote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	s = strings.Replace(s, "\n", "\\n", -1)
+	return "\"" + s + "\""
+}
@@ -0,0 +1,19 @@
+package read
+
+import "testing"
+
+func TestDotQuote(t *testing.T) {
+	for _, tt := range []struct {
+		s, want string
+	}{
+		{"", `""`},
+		{"main.T", `"main.T"`},
+		{`struct { X int "json:\"x\"" }`, `"struct { X int \"json:\\\"x\\\"\" }"`},
+		{"a\nb", `"a\nb"`},
+		{`C:\dir`, `"C:\\dir"`},
+	} {
+		if got := DotQuote(tt.s); got != tt.want {
+			t.Errorf("DotQuote(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package read
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// newTestDump returns an empty dump for a little-endian, 64-bit
+// machine.
+func newTestDump() *TestDump {
+	return NewTestDump(binary.LittleEndian, 8)
+}
+
+// nodeSize is the size of the objects graphDump builds.
+const nodeSize = 32
+
+// graphDump returns a dump with one object of nodeSize bytes for each
+// entry of edges, which lists the objects it points to.  Object i is
+// at nodeAddr(i), so its ObjId is i.  The roots are other roots.
+func graphDump(t *testing.T, edges [][]int, roots ...int) *Dump {
+	const typAddr = 0x100
+	w := newTestDump()
+	w.Header(nodeAddr(0), nodeAddr(len(edges)))
+	w.Type(typAddr, nodeSize, "main.node", 0, 8, 16, 24)
+	for i, e := range edges {
+		if len(e) > nodeSize/8 {
+			t.Fatalf("node %d has %d edges, more than %d", i, len(e), nodeSize/8)
+		}
+		p := make([]uint64, nodeSize/8)
+		for j, k := range e {
+			p[j] = nodeAddr(k)
+		}
+		w.Object(nodeAddr(i), typAddr, TypeKindObject, w.Words(p...))
+	}
+	for _, r := range roots {
+		w.OtherRoot("root", nodeAddr(r))
+	}
+	w.End()
+	d, err := ReadFrom(bytes.NewReader(w.Bytes()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// nodeAddr returns the address of object i of a graphDump.
+func nodeAddr(i int) uint64 {
+	return 0x10000 + nodeSize*uint64(i)
+}
@@ -23,10 +23,12 @@ func (h *heap) Insert(addr uint64, value interface{}) {
 
 // Lookup finds and returns the pair whose address is maximum among
 // all the inserted pairs with address less than or equal to addr.  If
+// several pairs have that address, the one inserted last wins.  If
 // none exist, returns 0, nil.
 func (h *heap) Lookup(addr uint64) (uint64, interface{}) {
 	if !h.sorted {
-		sort.Sort(byEntryAddr(h.entries))
+		// stable, so duplicates stay in insertion order
+		sort.Stable(byEntryAddr(h.entries))
 		h.sorted = true
 	}
 	j := sort.Search(len(h.entries), func(i int) bool { return addr < h.entries[i].addr })
@@ -42,6 +44,7 @@ type entry struct {
 }
 
 type byEntryAddr []entry
+
 func (h byEntryAddr) Len() int           { return len(h) }
 func (h byEntryAddr) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
 func (h byEntryAddr) Less(i, j int) bool { return h[i].addr < h[j].addr }
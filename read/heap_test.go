@@ -0,0 +1,60 @@
+package read
+
+import "testing"
+
+func TestHeapLookup(t *testing.T) {
+	var h heap
+	h.Insert(0x30, "c")
+	h.Insert(0x10, "a")
+	h.Insert(0x20, "b")
+	tests := []struct {
+		addr      uint64
+		wantAddr  uint64
+		wantValue interface{}
+	}{
+		{0x0f, 0, nil}, // below the first entry
+		{0x10, 0x10, "a"},
+		{0x1f, 0x10, "a"},
+		{0x20, 0x20, "b"},
+		{0x30, 0x30, "c"},
+		{0x31, 0x30, "c"}, // above the last entry
+		{^uint64(0), 0x30, "c"},
+	}
+	for _, test := range tests {
+		addr, value := h.Lookup(test.addr)
+		if addr != test.wantAddr || value != test.wantValue {
+			t.Errorf("Lookup(%#x) = %#x, %v; want %#x, %v", test.addr, addr, value, test.wantAddr, test.wantValue)
+		}
+	}
+}
+
+func TestHeapLookupEmpty(t *testing.T) {
+	var h heap
+	if addr, value := h.Lookup(0x10); addr != 0 || value != nil {
+		t.Errorf("Lookup on an empty heap = %#x, %v; want 0, nil", addr, value)
+	}
+}
+
+func TestHeapLookupDuplicates(t *testing.T) {
+	// Enough duplicates that an unstable sort would reorder them.
+	var h heap
+	const n = 100
+	for i := 0; i < n; i++ {
+		h.Insert(0x10, i)
+		h.Insert(0x20, -i)
+	}
+	for _, addr := range []uint64{0x10, 0x18} {
+		if a, v := h.Lookup(addr); a != 0x10 || v != n-1 {
+			t.Errorf("Lookup(%#x) = %#x, %v; want 0x10, %d, the last inserted", addr, a, v, n-1)
+		}
+	}
+	if a, v := h.Lookup(0x20); a != 0x20 || v != -(n-1) {
+		t.Errorf("Lookup(0x20) = %#x, %v; want 0x20, %d, the last inserted", a, v, -(n - 1))
+	}
+
+	// Inserting after a lookup re-sorts, and the newest still wins.
+	h.Insert(0x10, "new")
+	if _, v := h.Lookup(0x10); v != "new" {
+		t.Errorf("Lookup(0x10) after another insert = %v, want new", v)
+	}
+}
@@ -0,0 +1,51 @@
+package read
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadLabels attaches the labels in the file filename to the objects
+// of the dump.  Each line of the file is an address in hex, a tab, and
+// the label of the object containing that address.  Blank lines and
+// lines starting with # are ignored.
+func (d *Dump) LoadLabels(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if d.labels == nil {
+		d.labels = map[ObjId]string{}
+	}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		i := strings.IndexByte(line, '\t')
+		if i < 0 {
+			return fmt.Errorf("%s:%d: no tab after address", filename, n)
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(line[:i], "0x"), 16, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", filename, n, err)
+		}
+		x := d.FindObj(addr)
+		if x == ObjNil {
+			return fmt.Errorf("%s:%d: no object at %x", filename, n, addr)
+		}
+		d.labels[x] = line[i+1:]
+	}
+	return s.Err()
+}
+
+// Label returns the label that LoadLabels gave object x, or "" if it
+// has none.
+func (d *Dump) Label(x ObjId) string {
+	return d.labels[x]
+}
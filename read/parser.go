@@ -2,6 +2,7 @@ package read
 
 import (
 	"bufio"
+	"bytes"
 	"debug/dwarf"
 	"debug/elf"
 	"debug/macho"
@@ -11,10 +12,13 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"regexp"
 	"runtime"
 	"sort"
+	"strings"
+	"time"
 )
 
 type FieldKind int
@@ -74,6 +78,7 @@ const (
 	dw_op_call_frame_cfa = 156
 	dw_op_consts         = 17
 	dw_op_plus           = 34
+	dw_op_plus_uconst    = 35
 	dw_op_addr           = 3
 	dw_ate_boolean       = 2
 	dw_ate_complex_float = 3 // complex64/complex128
@@ -88,6 +93,28 @@ const (
 	bucketSize = 512
 )
 
+// tagNames names the record kinds, for error messages.
+var tagNames = map[uint64]string{
+	tagEOF:         "EOF",
+	tagObject:      "object",
+	tagOtherRoot:   "other root",
+	tagType:        "type",
+	tagGoRoutine:   "goroutine",
+	tagStackFrame:  "stack frame",
+	tagParams:      "params",
+	tagFinalizer:   "finalizer",
+	tagItab:        "itab",
+	tagOSThread:    "OS thread",
+	tagMemStats:    "memstats",
+	tagQFinal:      "queued finalizer",
+	tagData:        "data",
+	tagBss:         "bss",
+	tagDefer:       "defer",
+	tagPanic:       "panic",
+	tagMemProf:     "memprof",
+	tagAllocSample: "alloc sample",
+}
+
 type Dump struct {
 	Order        binary.ByteOrder
 	PtrSize      uint64 // in bytes
@@ -97,8 +124,9 @@ type Dump struct {
 	TheChar      byte
 	Experiment   string
 	Ncpu         uint64
+	ParamsStart  int64 // offset in the dump file of the params record
+	ParamsEnd    int64 // offset just past the params record
 	Types        []*Type
-	objects      []object
 	Frames       []*StackFrame
 	Goroutines   []*GoRoutine
 	Otherroots   []*OtherRoot
@@ -113,9 +141,45 @@ type Dump struct {
 	MemProf      []*MemProfEntry
 	AllocSamples []*AllocSample
 
+	// Time is when the dump was written.  The dump format doesn't
+	// record it, so Read uses the file's modification time and
+	// ReadFrom the time the dump was read.  Callers that know better
+	// may set it.
+	Time time.Time
+
+	// Truncated is set if the dump ended at a record boundary
+	// before its EOF record.  The records read so far are kept.
+	Truncated bool
+
+	// Dwarf records how well the types were named from the
+	// executable's DWARF info.
+	Dwarf DwarfStats
+
+	// BuildID is the Go build ID of the executable, if one was given
+	// and it has one.
+	BuildID string
+
 	// handle to dump file
 	r io.ReaderAt
 
+	// problems already reported by warnOnce
+	warned map[string]bool
+
+	// the executable's read-only data, if LoadRodata was set
+	rodata     []byte
+	rodataAddr uint64
+
+	// the executable's symbols, sorted by address
+	syms []Symbol
+
+	// labels of objects, from LoadLabels
+	labels map[ObjId]string
+
+	// objects found by link to extend outside the heap, or to
+	// overlap the next object
+	outside  []ObjId
+	overlaps []ObjId
+
 	buf []byte // temporary space for Contents calls
 
 	edges []Edge // temporary space for Edges calls
@@ -123,6 +187,16 @@ type Dump struct {
 	// list of full types, indexed by ID
 	FTList []*FullType
 
+	// map from base type name to the full types with that base type
+	ftByName map[string][]*FullType
+
+	// The objects in the heap, indexed by ObjId.  There will be a
+	// lot of objects, so we store them as parallel arrays instead
+	// of as a slice of Object to keep the per-object cost small.
+	objAddr   []uint64 // address of object
+	objFt     []int32  // index in FTList of the object's full type
+	objOffset []int64  // position of object contents in dump file
+
 	// map from type address to type
 	TypeMap map[uint64]*Type
 
@@ -136,6 +210,47 @@ type Dump struct {
 	// bytes in that bucket.
 	bucketSize uint64
 	idx        []ObjId
+
+	// reachable[x] is true if object x is reachable from the roots.
+	// Computed lazily, nil until then.
+	reachable []bool
+
+	// Referrer index.  If an object x has <= 1 distinct referrer, it
+	// is stored in ref1[x].  Otherwise, ref1[x] holds the first one
+	// and ref2[x] holds all of them.  Since most objects have only one
+	// referrer, ref2 ends up small.  Computed lazily, nil until then.
+	ref1 []ObjId
+	ref2 map[ObjId][]ObjId
+
+	// idom[x] is the immediate dominator of object x.  Objects
+	// immediately dominated by the roots map to the virtual root
+	// NumObjects().  Unreachable objects map to ObjNil.  domsize[x] is
+	// the size of the heap dominated by x.  Computed lazily, nil until then.
+	idom    []ObjId
+	domsize []uint64
+
+	// The dominator tree's children, in compressed form: the objects
+	// immediately dominated by x are kids[kidIdx[x]:kidIdx[x+1]].  The
+	// virtual root is x = NumObjects().  Computed lazily, nil until then.
+	kids   []ObjId
+	kidIdx []int
+
+	// pre[x] and post[x] are when a depth-first walk of the dominator
+	// tree enters and leaves x, so x dominates y exactly when
+	// pre[x] <= pre[y] and post[y] <= post[x].  Computed with kids.
+	pre, post []int
+}
+
+// DwarfStats counts what happened to the dump's types when naming
+// their fields from the DWARF info.  Poor coverage suggests that the
+// executable doesn't match the dump or has no DWARF info.  It is all
+// zero if no executable was given.
+type DwarfStats struct {
+	Types        int // types in the dump
+	Named        int // types whose fields were named
+	Missing      int // types with no DWARF type of the same name
+	Inconsistent int // types whose DWARF type disagrees with the dump
+	Skipped      int // types matching SkipNaming
 }
 
 type Type struct {
@@ -166,11 +281,23 @@ type Edge struct {
 
 	// name of field in the source object, if known
 	FieldName string
+
+	// Kind of the field in the source object the edge comes from:
+	// FieldKindPtr for a plain pointer, FieldKindSlice or FieldKindString
+	// for a backing store, FieldKindIface or FieldKindEface for the data
+	// word of an interface.  FieldKindEol for edges which don't come
+	// from a field, like those from other roots and finalizers.
+	Kind FieldKind
+
+	// Conservative is true if the source object was scanned
+	// conservatively, in which case the edge may come from a
+	// non-pointer word that happens to look like a heap address.
+	Conservative bool
 }
 
-// object represents an object in the heap.
-// There will be a lot of these.  They need to be small.
-type object struct {
+// Object represents an object record in the heap dump, as passed
+// to ReadObjects.  A Dump stores its objects more compactly.
+type Object struct {
 	Ft     *FullType
 	offset int64 // position of object contents in dump file
 	Addr   uint64
@@ -185,17 +312,25 @@ const (
 // NumObjects returns the number of objects in the heap.  Valid
 // ObjIds for other calls are from 0 to NumObjects()-1.
 func (d *Dump) NumObjects() int {
-	return len(d.objects)
+	return len(d.objAddr)
 }
 func (d *Dump) Contents(i ObjId) []byte {
-	x := d.objects[i]
+	return d.ContentsPrefix(i, d.Size(i))
+}
+
+// ContentsPrefix returns the first size bytes of object i, or all of
+// it if it is smaller.  Only those bytes are read from the dump.
+func (d *Dump) ContentsPrefix(i ObjId, size uint64) []byte {
+	if n := d.Size(i); size > n {
+		size = n
+	}
 	b := d.buf
-	if uint64(cap(b)) < x.Ft.Size {
-		b = make([]byte, x.Ft.Size)
+	if uint64(cap(b)) < size {
+		b = make([]byte, size)
 		d.buf = b
 	}
-	b = b[:x.Ft.Size]
-	n, err := d.r.ReadAt(b, x.offset)
+	b = b[:size]
+	n, err := d.r.ReadAt(b, d.objOffset[i])
 	if err != nil && !(n == len(b) && err == io.EOF) {
 		// TODO: propagate to caller
 		log.Fatal(err)
@@ -203,13 +338,145 @@ func (d *Dump) Contents(i ObjId) []byte {
 	return b
 }
 func (d *Dump) Addr(x ObjId) uint64 {
-	return d.objects[x].Addr
+	return d.objAddr[x]
 }
 func (d *Dump) Size(x ObjId) uint64 {
-	return d.objects[x].Ft.Size
+	return d.FTList[d.objFt[x]].Size
 }
 func (d *Dump) Ft(x ObjId) *FullType {
-	return d.objects[x].Ft
+	return d.FTList[d.objFt[x]]
+}
+
+// TotalBytes returns the total size of all objects in the heap,
+// whether reachable or not.
+func (d *Dump) TotalBytes() uint64 {
+	var n uint64
+	for _, ft := range d.objFt {
+		n += d.FTList[ft].Size
+	}
+	return n
+}
+
+// NumLiveObjects returns the number of objects reachable from the roots.
+func (d *Dump) NumLiveObjects() int {
+	n := 0
+	for _, r := range d.Reachable() {
+		if r {
+			n++
+		}
+	}
+	return n
+}
+
+// LiveBytes returns the total size of all objects reachable from the
+// roots.  The difference between TotalBytes and LiveBytes is garbage
+// that had not yet been collected when the dump was taken.
+func (d *Dump) LiveBytes() uint64 {
+	var n uint64
+	for i, r := range d.Reachable() {
+		if r {
+			n += d.Size(ObjId(i))
+		}
+	}
+	return n
+}
+
+// ConservativeDensity scans the words of all the conservatively
+// scanned objects and returns how many of them point into an object,
+// and how many words there are in all.  The ratio estimates how much
+// of the conservative part of the graph is made of real pointers.
+func (d *Dump) ConservativeDensity() (resolved, words int) {
+	for i := 0; i < d.NumObjects(); i++ {
+		x := ObjId(i)
+		if d.Ft(x).Kind != TypeKindConservative {
+			continue
+		}
+		b := d.Contents(x)
+		for off := uint64(0); off+d.PtrSize <= uint64(len(b)); off += d.PtrSize {
+			words++
+			if d.FindObj(d.ReadPtr(b[off:])) != ObjNil {
+				resolved++
+			}
+		}
+	}
+	return resolved, words
+}
+
+// A Gap is a range [Start,End) of the heap that holds no object.
+type Gap struct {
+	Start, End uint64
+}
+
+// HeapGaps returns, in address order, the ranges of the heap span
+// [HeapStart,HeapEnd) not covered by any object.  Their total size is
+// free space, so it measures how fragmented the heap is.
+func (d *Dump) HeapGaps() []Gap {
+	var gaps []Gap
+	p := d.HeapStart
+	for i, a := range d.objAddr {
+		if a > p {
+			gaps = append(gaps, Gap{p, a})
+		}
+		if end := a + d.Size(ObjId(i)); end > p {
+			p = end
+		}
+	}
+	if d.HeapEnd > p {
+		gaps = append(gaps, Gap{p, d.HeapEnd})
+	}
+	return gaps
+}
+
+// Live reports whether object x is reachable from the roots.
+func (d *Dump) Live(x ObjId) bool {
+	return d.Reachable()[x]
+}
+
+// Reachable returns a bitmap, indexed by ObjId, of the objects which
+// are reachable from the roots: the data and bss sections, the stack
+// frames, the other roots, the finalizer queue, and each goroutine's
+// context.  Objects with a false entry are garbage the GC had not yet
+// collected when the dump was taken.  The bitmap is computed once and
+// shared, so callers must not modify it.
+func (d *Dump) Reachable() []bool {
+	if d.reachable != nil {
+		return d.reachable
+	}
+	reachable := make([]bool, d.NumObjects())
+	var q []ObjId
+	add := func(edges []Edge) {
+		for _, e := range edges {
+			if !reachable[e.To] {
+				reachable[e.To] = true
+				q = append(q, e.To)
+			}
+		}
+	}
+	for _, f := range d.Frames {
+		add(f.Edges)
+	}
+	for _, x := range []*Data{d.Data, d.Bss} {
+		add(x.Edges)
+	}
+	for _, r := range d.Otherroots {
+		add(r.Edges)
+	}
+	for _, f := range d.QFinal {
+		add(f.Edges)
+	}
+	for _, g := range d.Goroutines {
+		if g.Ctxt != ObjNil && !reachable[g.Ctxt] {
+			reachable[g.Ctxt] = true
+			q = append(q, g.Ctxt)
+		}
+	}
+	for len(q) > 0 {
+		x := q[len(q)-1]
+		q = q[:len(q)-1]
+		add(d.Edges(x))
+	}
+	d.reachable = reachable
+	return reachable
 }
 
 // FindObj returns the object id containing the address addr, or -1 if no object contains addr.
@@ -218,66 +485,126 @@ func (d *Dump) FindObj(addr uint64) ObjId {
 		return ObjNil
 	}
 	// linear search among all the objects that map to the same bucketSize-byte bucket.
-	for i := d.idx[(addr-d.HeapStart)/bucketSize]; i < ObjId(len(d.objects)); i++ {
-		x := &d.objects[i]
-		if addr < x.Addr {
+	for i := d.idx[(addr-d.HeapStart)/bucketSize]; i < ObjId(d.NumObjects()); i++ {
+		a := d.objAddr[i]
+		if addr < a {
 			return ObjNil
 		}
-		if addr < x.Addr+x.Ft.Size {
+		if addr < a+d.Size(i) {
 			return ObjId(i)
 		}
 	}
 	return ObjNil
 }
 
+// ObjectsInRange returns the objects whose addresses are in [lo, hi),
+// in increasing address order.
+func (d *Dump) ObjectsInRange(lo, hi uint64) []ObjId {
+	i := sort.Search(d.NumObjects(), func(i int) bool { return d.objAddr[i] >= lo })
+	j := sort.Search(d.NumObjects(), func(i int) bool { return d.objAddr[i] >= hi })
+	var r []ObjId
+	for ; i < j; i++ {
+		r = append(r, ObjId(i))
+	}
+	return r
+}
+
+// Edges returns the edges from object i, in increasing order of the
+// offset they leave from.  The result is only valid until the next
+// call to Edges.
 func (d *Dump) Edges(i ObjId) []Edge {
-	x := &d.objects[i]
+	return d.EdgesPrefix(i, d.Size(i))
+}
+
+// EdgesPrefix returns the edges from the fields in the first size
+// bytes of object i.  Like ContentsPrefix, it reads only those bytes.
+func (d *Dump) EdgesPrefix(i ObjId, size uint64) []Edge {
 	e := d.edges[:0]
-	b := d.Contents(i)
-	for _, f := range x.Ft.Fields {
+	d.forEachEdge(i, size, func(x Edge) bool {
+		e = append(e, x)
+		return true
+	})
+	d.edges = e
+	return e
+}
+
+// ForEachEdge calls fn for each edge from object i, in the order Edges
+// returns them, until fn returns false.  It saves building the whole
+// list when the caller is looking for a particular edge.  fn must not
+// call Contents, Edges or ForEachEdge.
+func (d *Dump) ForEachEdge(i ObjId, fn func(Edge) bool) {
+	d.forEachEdge(i, d.Size(i), fn)
+}
+
+// forEachEdge is ForEachEdge for the fields in the first size bytes
+// of object i.
+func (d *Dump) forEachEdge(i ObjId, size uint64, fn func(Edge) bool) {
+	ft := d.Ft(i)
+	if len(ft.Fields) == 0 {
+		return
+	}
+	// Read only as far as the last field, which may be a two-word
+	// interface.
+	n := ft.Fields[len(ft.Fields)-1].Offset + 2*d.PtrSize
+	if n > size {
+		n = size
+	}
+	b := d.ContentsPrefix(i, n)
+	cons := ft.Kind == TypeKindConservative
+	for _, f := range ft.Fields {
+		off := f.Offset // where the pointer is
+		if off+d.PtrSize > uint64(len(b)) {
+			break
+		}
 		switch f.Kind {
 		case FieldKindPtr, FieldKindString, FieldKindSlice:
-			p := readPtr(d, b[f.Offset:])
-			y := d.FindObj(p)
-			if y != ObjNil {
-				e = append(e, Edge{y, f.Offset, p - d.objects[y].Addr, f.Name})
-			}
 		case FieldKindEface:
-			taddr := readPtr(d, b[f.Offset:])
-			if taddr != 0 {
-				t := d.TypeMap[taddr]
-				if t == nil {
-					log.Fatal("can't find eface type", taddr)
-				}
-				if t.efaceptr {
-					p := readPtr(d, b[f.Offset+d.PtrSize:])
-					y := d.FindObj(p)
-					if y != ObjNil {
-						e = append(e, Edge{y, f.Offset + d.PtrSize, p - d.objects[y].Addr, f.Name})
-					}
-				}
+			if off+2*d.PtrSize > uint64(len(b)) {
+				return
+			}
+			taddr := d.ReadPtr(b[f.Offset:])
+			if taddr == 0 {
+				continue
+			}
+			t := d.TypeMap[taddr]
+			if t == nil {
+				// partial dumps can lack type records
+				d.warnOnce("can't find eface type", taddr)
+				continue
+			}
+			if !t.efaceptr {
+				continue
 			}
+			off += d.PtrSize
 		case FieldKindIface:
-			itabaddr := readPtr(d, b[f.Offset:])
-			if itabaddr != 0 {
-				ptr, ok := d.ItabMap[itabaddr]
-				if !ok {
-					log.Fatal("can't find itab", itabaddr)
-				}
-				if ptr {
-					p := readPtr(d, b[f.Offset+d.PtrSize:])
-					y := d.FindObj(p)
-					if y != ObjNil {
-						e = append(e, Edge{y, f.Offset + d.PtrSize, p - d.objects[y].Addr, f.Name})
-					}
-				}
+			if off+2*d.PtrSize > uint64(len(b)) {
+				return
+			}
+			itabaddr := d.ReadPtr(b[f.Offset:])
+			if itabaddr == 0 {
+				continue
+			}
+			ptr, ok := d.ItabMap[itabaddr]
+			if !ok {
+				d.warnOnce("can't find itab", itabaddr)
+				continue
+			}
+			if !ptr {
+				continue
 			}
+			off += d.PtrSize
 		default:
 			continue
 		}
+		p := d.ReadPtr(b[off:])
+		y := d.FindObj(p)
+		if y == ObjNil {
+			continue
+		}
+		if !fn(Edge{y, off, p - d.objAddr[y], f.Name, f.Kind, cons}) {
+			return
+		}
 	}
-	d.edges = e
-	return e
 }
 
 type OtherRoot struct {
@@ -307,20 +634,20 @@ type QFinalizer struct {
 }
 
 type Defer struct {
-	addr uint64
+	Addr uint64
 	gp   uint64
-	argp uint64
-	pc   uint64
-	fn   uint64
-	code uint64
+	Argp uint64 // args pointer of the deferring frame
+	Pc   uint64 // pc of the defer statement
+	Fn   uint64 // funcval of the deferred function
+	Code uint64 // entry pc of the deferred function
 	link uint64
 }
 
 type Panic struct {
-	addr uint64
+	Addr uint64
 	gp   uint64
-	typ  uint64
-	data uint64
+	Typ  uint64 // type of the panic value, the key of TypeMap
+	Data uint64 // data word of the panic value
 	defr uint64
 	link uint64
 }
@@ -377,12 +704,46 @@ type GoRoutine struct {
 	Status       uint64
 	IsSystem     bool
 	IsBackground bool
-	WaitSince    uint64
+	WaitSince    uint64 // runtime clock, in ns since 1970; 0 if not waiting
 	WaitReason   string
 	ctxtaddr     uint64
 	maddr        uint64
 	deferaddr    uint64
 	panicaddr    uint64
+	defers       []*Defer
+	panics       []*Panic
+}
+
+// Defers returns the goroutine's pending deferred calls, the most
+// recently deferred first, which is the order they will run in.
+func (g *GoRoutine) Defers() []*Defer {
+	return g.defers
+}
+
+// Panics returns the goroutine's active panics, the most recent first.
+// Earlier panics are ones that a deferred call of a later one was
+// running for.
+func (g *GoRoutine) Panics() []*Panic {
+	return g.panics
+}
+
+// earliestWait is the smallest WaitSince we believe is a wall clock
+// time, the start of 2000.  Runtimes whose clock counts from some
+// other origin give smaller values.
+const earliestWait = 946684800 * 1e9
+
+// WaitTime returns how long goroutine g had been waiting when the dump
+// was taken, based on the dump's Time.  It reports false if g was not
+// waiting or its wait start isn't a wall clock time.
+func (d *Dump) WaitTime(g *GoRoutine) (time.Duration, bool) {
+	if g.WaitSince < earliestWait || d.Time.IsZero() {
+		return 0, false
+	}
+	w := d.Time.Sub(time.Unix(0, int64(g.WaitSince)))
+	if w < 0 {
+		w = 0
+	}
+	return w, true
 }
 
 type StackFrame struct {
@@ -400,27 +761,61 @@ type StackFrame struct {
 	Fields    []Field
 }
 
+// FramesByFunc returns the stack frames of the function with the given
+// name, in the order they appear in the dump.  Their goroutines are
+// the ones running, or blocked in, that function.
+func (d *Dump) FramesByFunc(name string) []*StackFrame {
+	var r []*StackFrame
+	for _, f := range d.Frames {
+		if f.Name == name {
+			r = append(r, f)
+		}
+	}
+	return r
+}
+
 // both an io.Reader and an io.ByteReader
 type Reader interface {
 	Read(p []byte) (n int, err error)
 	ReadByte() (c byte, err error)
 }
 
+// A readError is a failure to decode a value from the dump.  The read
+// functions below panic with one, and readRecords turns it into an
+// error saying where in the dump it happened.
+type readError struct {
+	what string // the kind of value being read
+	err  error
+}
+
+// fail panics with a readError.  A dump which ends in the middle of a
+// value is reported as such, not as a plain EOF.
+func fail(what string, err error) {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	panic(readError{what, err})
+}
+
 func readUint64(r Reader) uint64 {
 	x, err := binary.ReadUvarint(r)
 	if err != nil {
-		log.Fatal(err)
+		fail("varint", err)
 	}
 	return x
 }
 
 func readNBytes(r Reader, n uint64) []byte {
-	s := make([]byte, n)
-	_, err := io.ReadFull(r, s)
-	if err != nil {
-		log.Fatal(err)
+	// Don't trust n with an allocation up front: a corrupt length
+	// could be huge.  Let the buffer grow as the bytes arrive.
+	if n > math.MaxInt64 {
+		fail("byte string", fmt.Errorf("length %d too large", n))
 	}
-	return s
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, r, int64(n)); err != nil {
+		fail("byte string", err)
+	}
+	return b.Bytes()
 }
 
 func readBytes(r Reader) []byte {
@@ -435,7 +830,7 @@ func readString(r Reader) string {
 func readBool(r Reader) bool {
 	b, err := r.ReadByte()
 	if err != nil {
-		log.Fatal(err)
+		fail("bool", err)
 	}
 	return b != 0
 }
@@ -445,7 +840,11 @@ func readFields(r Reader) []Field {
 	for {
 		kind := FieldKind(readUint64(r))
 		if kind == FieldKindEol {
-			// TODO: sort by offset, or check that it is sorted
+			// Everything downstream assumes increasing offsets,
+			// but the dump format doesn't promise them.
+			if !sort.IsSorted(byOffset(x)) {
+				sort.Stable(byOffset(x))
+			}
 			return x
 		}
 		x = append(x, Field{Kind: kind, Offset: readUint64(r)})
@@ -456,6 +855,19 @@ func readFields(r Reader) []Field {
 type myReader struct {
 	r   *bufio.Reader
 	cnt int64
+	f   io.ReadSeeker // the file r reads, if it can seek
+}
+
+// newMyReader returns a reader of file.  If file can seek, skipping
+// the contents of big objects seeks past them instead of reading them.
+func newMyReader(file io.Reader) *myReader {
+	r := &myReader{r: bufio.NewReader(file)}
+	if f, ok := file.(io.ReadSeeker); ok {
+		if _, err := f.Seek(0, io.SeekCurrent); err == nil {
+			r.f = f
+		}
+	}
+	return r
 }
 
 func (r *myReader) Read(p []byte) (n int, err error) {
@@ -477,6 +889,27 @@ func (r *myReader) ReadLine() (line []byte, isPrefix bool, err error) {
 	return
 }
 func (r *myReader) Skip(n int64) error {
+	if b := int64(r.r.Buffered()); r.f != nil && n > b {
+		// The file is b bytes past our offset.
+		pos, err := r.f.Seek(n-b, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		end, err := r.f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if pos > end {
+			r.cnt += n - (pos - end)
+			return io.ErrUnexpectedEOF
+		}
+		if _, err := r.f.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		r.r.Reset(r.f)
+		r.cnt += n
+		return nil
+	}
 	k, err := io.CopyN(ioutil.Discard, r.r, n)
 	r.cnt += k
 	return err
@@ -494,7 +927,13 @@ type tkey struct {
 func (d *Dump) makeFullType(typaddr uint64, kind TypeKind, size uint64) *FullType {
 	t := d.TypeMap[typaddr]
 	if typaddr != 0 && t == nil {
-		log.Fatal("types appear before use of that type")
+		fail("object type", fmt.Errorf("type %#x appears before its type record", typaddr))
+	}
+	if t == nil && (kind == TypeKindArray || kind == TypeKindChan) {
+		fail("object type", fmt.Errorf("object of kind %d and size %d has no element type", kind, size))
+	}
+	if size > math.MaxInt64 {
+		fail("object size", fmt.Errorf("size %d too large", size))
 	}
 	var name string
 	switch kind {
@@ -505,10 +944,14 @@ func (d *Dump) makeFullType(typaddr uint64, kind TypeKind, size uint64) *FullTyp
 			name = fmt.Sprintf("noptr%d", size)
 		}
 	case TypeKindArray:
-		name = fmt.Sprintf("{%d}%s", size/t.Size, t.Name)
+		if t.Size > 0 {
+			name = fmt.Sprintf("{%d}%s", size/t.Size, t.Name)
+		} else {
+			name = fmt.Sprintf("{inf}%s", t.Name)
+		}
 	case TypeKindChan:
 		if d.HChanSize == 0 {
-			log.Fatal("hchansize must be before objects")
+			fail("object type", fmt.Errorf("channel object before the params record"))
 		}
 		if t.Size > 0 {
 			name = fmt.Sprintf("chan{%d}%s", (size-d.HChanSize)/t.Size, t.Name)
@@ -524,33 +967,117 @@ func (d *Dump) makeFullType(typaddr uint64, kind TypeKind, size uint64) *FullTyp
 }
 
 // Reads heap dump into memory.
-func rawRead(filename string) *Dump {
+func rawRead(filename string) (*Dump, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	r := &myReader{r: bufio.NewReader(file)}
+	d := &Dump{}
+	d.r = file
+	if fi, err := file.Stat(); err == nil {
+		d.Time = fi.ModTime()
+	}
+	if err := readRecords(file, d, d.addObject); err != nil {
+		file.Close()
+		return nil, err
+	}
+	// TODO: any easy way to truncate the objects array?  We could
+	// reclaim the fraction that append() added but we didn't need.
+	return d, nil
+}
+
+// addObject adds obj to the objects of d.
+func (d *Dump) addObject(obj Object) {
+	d.objAddr = append(d.objAddr, obj.Addr)
+	d.objFt = append(d.objFt, int32(obj.Ft.Id))
+	d.objOffset = append(d.objOffset, obj.offset)
+}
+
+// fillMissing supplies empty versions of the records that a
+// truncated dump may be missing, so that code using the Dump doesn't
+// have to check for them.
+func fillMissing(d *Dump) {
+	if d.Data == nil {
+		d.Data = &Data{}
+	}
+	if d.Bss == nil {
+		d.Bss = &Data{}
+	}
+	if d.Memstats == nil {
+		d.Memstats = &runtime.MemStats{}
+	}
+}
+
+// ReadObjects reads the heap dump in dumpname and calls fn for each
+// object record, in the order the records appear in the dump.  Unlike
+// Read, it does not retain the objects, so it can process dumps
+// too large to hold in memory.  The ObjId passed to fn is the index of
+// the object in the dump file; it is not valid for Dump methods.
+// The FullType of each object has a name and size, but no fields.
+func ReadObjects(dumpname string, fn func(ObjId, *Object)) error {
+	file, err := os.Open(dumpname)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var d Dump
+	n := 0
+	return readRecords(file, &d, func(obj Object) {
+		fn(ObjId(n), &obj)
+		n++
+	})
+}
+
+// readRecords reads all the records of the heap dump from file into d,
+// except for objects, which are passed to objfn.
+//
+// Records carry no length, so a record of a kind we don't know can't
+// be skipped.  Instead we stop and return an error naming the kind
+// and where the record starts.  Errors in the middle of a record also
+// give where the bad value is and the kind of record it is in.
+func readRecords(file io.Reader, d *Dump, objfn func(Object)) (err error) {
+	r := newMyReader(file)
+	var kind uint64
+	var start int64
+	defer func() {
+		if e := recover(); e != nil {
+			re, ok := e.(readError)
+			if !ok {
+				panic(e)
+			}
+			err = fmt.Errorf("bad %s at offset %d in %s record at offset %d: %v", re.what, r.Count(), tagNames[kind], start, re.err)
+		}
+	}()
 
 	// check for header
 	hdr, prefix, err := r.ReadLine()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	if prefix || string(hdr) != "go1.3 heap dump" {
-		log.Fatal("not a go1.3 heap dump file")
+		return fmt.Errorf("not a go1.3 heap dump file")
 	}
 
-	var d Dump
-	d.r = file
 	d.ItabMap = map[uint64]bool{}
 	d.TypeMap = map[uint64]*Type{}
 	ftmap := map[tkey]*FullType{} // full type dedup
 	memprof := map[uint64]*MemProfEntry{}
 	for {
-		kind := readUint64(r)
+		start = r.Count()
+		kind, err = binary.ReadUvarint(r)
+		if err == io.EOF {
+			// The dump ended between records.  Keep what we have.
+			log.Print("heap dump is truncated, missing EOF record")
+			d.Truncated = true
+			fillMissing(d)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 		switch kind {
 		case tagObject:
-			obj := object{}
+			obj := Object{}
 			obj.Addr = readUint64(r)
 			typaddr := readUint64(r)
 			kind := TypeKind(readUint64(r))
@@ -563,10 +1090,12 @@ func rawRead(filename string) *Dump {
 			}
 			obj.Ft = ft
 			obj.offset = r.Count()
-			r.Skip(int64(ft.Size))
-			d.objects = append(d.objects, obj)
+			if err := r.Skip(int64(ft.Size)); err != nil {
+				fail("object contents", err)
+			}
+			objfn(obj)
 		case tagEOF:
-			return &d
+			return nil
 		case tagOtherRoot:
 			t := &OtherRoot{}
 			t.Description = readString(r)
@@ -626,6 +1155,8 @@ func rawRead(filename string) *Dump {
 			d.TheChar = byte(readUint64(r))
 			d.Experiment = readString(r)
 			d.Ncpu = readUint64(r)
+			d.ParamsStart = start
+			d.ParamsEnd = r.Count()
 		case tagFinalizer:
 			t := &Finalizer{}
 			t.obj = readUint64(r)
@@ -697,20 +1228,20 @@ func rawRead(filename string) *Dump {
 			d.Memstats = t
 		case tagDefer:
 			t := &Defer{}
-			t.addr = readUint64(r)
+			t.Addr = readUint64(r)
 			t.gp = readUint64(r)
-			t.argp = readUint64(r)
-			t.pc = readUint64(r)
-			t.fn = readUint64(r)
-			t.code = readUint64(r)
+			t.Argp = readUint64(r)
+			t.Pc = readUint64(r)
+			t.Fn = readUint64(r)
+			t.Code = readUint64(r)
 			t.link = readUint64(r)
 			d.Defers = append(d.Defers, t)
 		case tagPanic:
 			t := &Panic{}
-			t.addr = readUint64(r)
+			t.Addr = readUint64(r)
 			t.gp = readUint64(r)
-			t.typ = readUint64(r)
-			t.data = readUint64(r)
+			t.Typ = readUint64(r)
+			t.Data = readUint64(r)
 			t.defr = readUint64(r)
 			t.link = readUint64(r)
 			d.Panics = append(d.Panics, t)
@@ -736,11 +1267,9 @@ func rawRead(filename string) *Dump {
 			t.Prof = memprof[readUint64(r)]
 			d.AllocSamples = append(d.AllocSamples, t)
 		default:
-			log.Fatal("unknown record kind ", kind)
+			return fmt.Errorf("unknown record kind %d at offset %d", kind, start)
 		}
 	}
-	// TODO: any easy way to truncate the objects array?  We could
-	// reclaim the fraction that append() added but we didn't need.
 }
 
 func getDwarf(execname string) *dwarf.Data {
@@ -751,6 +1280,9 @@ func getDwarf(execname string) *dwarf.Data {
 		if err == nil {
 			return d
 		}
+		if d := debugLinkDwarf(e, execname); d != nil {
+			return d
+		}
 	}
 	m, err := macho.Open(execname)
 	if err == nil {
@@ -897,6 +1429,16 @@ func (t *dwarfTypedef) Size() uint64 {
 
 var unkBase = "unkBase"
 
+// SkipNaming, if non-nil, matches the names of types whose fields
+// should not be named from the DWARF info.  Their fields are left
+// unnamed, which saves the consistency check on huge generated types.
+var SkipNaming *regexp.Regexp
+
+// DwarfLog receives the details of mismatches between the DWARF info
+// and the types in the heap dump.  There are many of these on a real
+// binary, so by default they are discarded.
+var DwarfLog = log.New(ioutil.Discard, "", log.LstdFlags)
+
 func (t *dwarfPtrType) Fields() []Field {
 	if t.fields == nil {
 		if t.Name()[0] == '*' {
@@ -1007,19 +1549,19 @@ func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
 		switch e.Tag {
 		case dwarf.TagBaseType:
 			x := new(dwarfBaseType)
-			x.name = e.Val(dwarf.AttrName).(string)
-			x.size = uint64(e.Val(dwarf.AttrByteSize).(int64))
-			x.encoding = e.Val(dwarf.AttrEncoding).(int64)
+			x.name = attrString(e, dwarf.AttrName)
+			x.size = uint64(attrInt(e, dwarf.AttrByteSize))
+			x.encoding = attrInt(e, dwarf.AttrEncoding)
 			t[e.Offset] = x
 		case dwarf.TagPointerType:
 			x := new(dwarfPtrType)
-			x.name = e.Val(dwarf.AttrName).(string)
+			x.name = attrString(e, dwarf.AttrName)
 			x.size = d.PtrSize
 			t[e.Offset] = x
 		case dwarf.TagStructType:
 			x := new(dwarfStructType)
-			x.name = e.Val(dwarf.AttrName).(string)
-			x.size = uint64(e.Val(dwarf.AttrByteSize).(int64))
+			x.name = attrString(e, dwarf.AttrName)
+			x.size = uint64(attrInt(e, dwarf.AttrByteSize))
 			for _, a := range adjTypeNames {
 				if k := a.matcher.FindStringSubmatch(x.name); k != nil {
 					var i []interface{}
@@ -1032,16 +1574,16 @@ func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
 			t[e.Offset] = x
 		case dwarf.TagArrayType:
 			x := new(dwarfArrayType)
-			x.name = e.Val(dwarf.AttrName).(string)
-			x.size = uint64(e.Val(dwarf.AttrByteSize).(int64))
+			x.name = attrString(e, dwarf.AttrName)
+			x.size = uint64(attrInt(e, dwarf.AttrByteSize))
 			t[e.Offset] = x
 		case dwarf.TagTypedef:
 			x := new(dwarfTypedef)
-			x.name = e.Val(dwarf.AttrName).(string)
+			x.name = attrString(e, dwarf.AttrName)
 			t[e.Offset] = x
 		case dwarf.TagSubroutineType:
 			x := new(dwarfFuncType)
-			x.name = e.Val(dwarf.AttrName).(string)
+			x.name = attrString(e, dwarf.AttrName)
 			x.size = d.PtrSize
 			t[e.Offset] = x
 		}
@@ -1060,32 +1602,43 @@ func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
 		}
 		switch e.Tag {
 		case dwarf.TagTypedef:
-			t[e.Offset].(*dwarfTypedef).type_ = t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			if t[e.Offset].(*dwarfTypedef).type_ == nil {
-				log.Fatalf("can't find referent for %s %d\n", t[e.Offset].(*dwarfTypedef).name, e.Val(dwarf.AttrType).(dwarf.Offset))
+			x, ok := t[e.Offset].(*dwarfTypedef)
+			if !ok {
+				break
+			}
+			x.type_ = t[attrOffset(e, dwarf.AttrType)]
+			if x.type_ == nil {
+				log.Fatalf("can't find referent for %s %d\n", x.name, attrOffset(e, dwarf.AttrType))
 			}
 		case dwarf.TagPointerType:
-			i := e.Val(dwarf.AttrType)
-			if i != nil {
-				t[e.Offset].(*dwarfPtrType).elem = t[i.(dwarf.Offset)]
+			x, ok := t[e.Offset].(*dwarfPtrType)
+			if !ok {
+				break
+			}
+			if i := attrOffset(e, dwarf.AttrType); i != 0 {
+				x.elem = t[i]
 			}
 			// The only nil cases are unsafe.Pointer and reflect.iword
 		case dwarf.TagArrayType:
-			t[e.Offset].(*dwarfArrayType).elem = t[e.Val(dwarf.AttrType).(dwarf.Offset)]
+			if x, ok := t[e.Offset].(*dwarfArrayType); ok {
+				x.elem = t[attrOffset(e, dwarf.AttrType)]
+			}
 		case dwarf.TagStructType:
-			currentStruct = t[e.Offset].(*dwarfStructType)
+			// nil for unnamed (non-Go) structs, whose members we skip.
+			currentStruct, _ = t[e.Offset].(*dwarfStructType)
 		case dwarf.TagMember:
-			name := e.Val(dwarf.AttrName).(string)
-			type_ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			loc := e.Val(dwarf.AttrDataMemberLoc).([]uint8)
-			var offset uint64
-			if len(loc) == 0 {
-				offset = 0
-			} else if len(loc) >= 2 && loc[0] == dw_op_consts && loc[len(loc)-1] == dw_op_plus {
-				loc, offset = readUleb(loc[1 : len(loc)-1])
-				if len(loc) != 0 {
-					break
-				}
+			if currentStruct == nil {
+				break
+			}
+			name := attrString(e, dwarf.AttrName)
+			type_ := t[attrOffset(e, dwarf.AttrType)]
+			if name == "" && type_ != nil {
+				// anonymous (embedded) member
+				name = embeddedName(type_.Name())
+			}
+			offset, ok := memberOffset(e)
+			if !ok {
+				break
 			}
 			currentStruct.members = append(currentStruct.members, dwarfTypeMember{name, offset, type_})
 		}
@@ -1093,6 +1646,73 @@ func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
 	return t
 }
 
+// memberOffset returns the offset of the struct member described by e.
+// Older toolchains encode DW_AT_data_member_location as a location
+// expression, newer ones (and DWARF 4+) as a constant.  It reports false
+// if the location is an expression it doesn't understand.
+func memberOffset(e *dwarf.Entry) (uint64, bool) {
+	switch loc := e.Val(dwarf.AttrDataMemberLoc).(type) {
+	case nil:
+		return 0, true
+	case int64:
+		return uint64(loc), true
+	case []uint8:
+		var offset uint64
+		switch {
+		case len(loc) == 0:
+			return 0, true
+		case len(loc) >= 2 && loc[0] == dw_op_consts && loc[len(loc)-1] == dw_op_plus:
+			loc, offset = readUleb(loc[1 : len(loc)-1])
+		case loc[0] == dw_op_plus_uconst:
+			loc, offset = readUleb(loc[1:])
+		default:
+			return 0, false
+		}
+		return offset, len(loc) == 0
+	}
+	return 0, false
+}
+
+// embeddedName returns the name Go gives a field embedding the type
+// named typ: the type name without its pointer, package, or type
+// arguments.  For example, *sync.Mutex is embedded as Mutex.
+func embeddedName(typ string) string {
+	typ = strings.TrimPrefix(typ, "*")
+	if i := strings.IndexByte(typ, '['); i >= 0 {
+		typ = typ[:i]
+	}
+	return typ[strings.LastIndexByte(typ, '.')+1:]
+}
+
+// attrString returns the string value of attribute a of e, or "" if
+// it is missing or not a string.
+func attrString(e *dwarf.Entry, a dwarf.Attr) string {
+	s, _ := e.Val(a).(string)
+	return s
+}
+
+// attrInt returns the integer value of attribute a of e, or 0 if it
+// is missing or not an integer.
+func attrInt(e *dwarf.Entry, a dwarf.Attr) int64 {
+	i, _ := e.Val(a).(int64)
+	return i
+}
+
+// attrOffset returns the offset value of attribute a of e, or 0 if it
+// is missing or not a reference.  No type lives at offset 0.
+func attrOffset(e *dwarf.Entry, a dwarf.Attr) dwarf.Offset {
+	o, _ := e.Val(a).(dwarf.Offset)
+	return o
+}
+
+// attrBlock returns the location expression in attribute a of e, or
+// nil if it is missing or in another form, such as a DWARF 5 location
+// list reference.
+func attrBlock(e *dwarf.Entry, a dwarf.Attr) []uint8 {
+	b, _ := e.Val(a).([]uint8)
+	return b
+}
+
 type localKey struct {
 	funcname string
 	offset   uint64 // distance down from frame pointer
@@ -1113,11 +1733,11 @@ func localsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[localKe
 		}
 		switch e.Tag {
 		case dwarf.TagSubprogram:
-			funcname = e.Val(dwarf.AttrName).(string)
+			funcname = attrString(e, dwarf.AttrName)
 		case dwarf.TagVariable:
-			name := e.Val(dwarf.AttrName).(string)
-			typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			loc := e.Val(dwarf.AttrLocation).([]uint8)
+			name := attrString(e, dwarf.AttrName)
+			typ := t[attrOffset(e, dwarf.AttrType)]
+			loc := attrBlock(e, dwarf.AttrLocation)
 			if len(loc) == 0 || loc[0] != dw_op_call_frame_cfa {
 				break
 			}
@@ -1130,6 +1750,9 @@ func localsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[localKe
 					break
 				}
 			}
+			if typ == nil {
+				break
+			}
 			for _, f := range typ.Fields() {
 				m[localKey{funcname, uint64(-offset) - f.Offset}] = joinNames(name, f.Name)
 			}
@@ -1153,14 +1776,14 @@ func argsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[localKey]
 		}
 		switch e.Tag {
 		case dwarf.TagSubprogram:
-			funcname = e.Val(dwarf.AttrName).(string)
+			funcname = attrString(e, dwarf.AttrName)
 		case dwarf.TagFormalParameter:
 			if e.Val(dwarf.AttrName) == nil {
 				continue
 			}
-			name := e.Val(dwarf.AttrName).(string)
-			typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			loc := e.Val(dwarf.AttrLocation).([]uint8)
+			name := attrString(e, dwarf.AttrName)
+			typ := t[attrOffset(e, dwarf.AttrType)]
+			loc := attrBlock(e, dwarf.AttrLocation)
 			if len(loc) == 0 || loc[0] != dw_op_call_frame_cfa {
 				break
 			}
@@ -1173,8 +1796,13 @@ func argsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[localKey]
 					break
 				}
 			}
+			if typ == nil {
+				break
+			}
+			// Each word of a multi-word argument (a string, slice,
+			// or struct) gets its own name.
 			for _, f := range typ.Fields() {
-				m[localKey{funcname, uint64(offset)}] = joinNames(name, f.Name)
+				m[localKey{funcname, uint64(offset) + f.Offset}] = joinNames(name, f.Name)
 			}
 		}
 	}
@@ -1183,7 +1811,15 @@ func argsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[localKey]
 
 // map from global address to Field at that address
 func globalsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) *heap {
-	h := new(heap)
+	// Several variables, or a variable and one of its fields, can
+	// start at the same address.  Keep the most specific name for
+	// each address, so the result doesn't depend on sort order.
+	m := map[uint64]Field{}
+	add := func(addr uint64, f Field) {
+		if g, ok := m[addr]; !ok || moreSpecific(f, g) {
+			m[addr] = f
+		}
+	}
 	r := w.Reader()
 	for {
 		e, err := r.Next()
@@ -1196,25 +1832,43 @@ func globalsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) *heap {
 		if e.Tag != dwarf.TagVariable {
 			continue
 		}
-		name := e.Val(dwarf.AttrName).(string)
-		typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-		locexpr := e.Val(dwarf.AttrLocation).([]uint8)
+		name := attrString(e, dwarf.AttrName)
+		typ := t[attrOffset(e, dwarf.AttrType)]
+		locexpr := attrBlock(e, dwarf.AttrLocation)
 		if len(locexpr) == 0 || locexpr[0] != dw_op_addr {
 			continue
 		}
-		loc := readPtr(d, locexpr[1:])
+		loc := d.ReadPtr(locexpr[1:])
 		if typ == nil {
 			// lots of non-Go global symbols hit here (rodata, reflect.cvtFloat·f, ...)
-			h.Insert(loc, Field{FieldKindPtr, 0, "~" + name, ""})
+			add(loc, Field{FieldKindPtr, 0, "~" + name, ""})
 			continue
 		}
 		for _, f := range typ.Fields() {
-			h.Insert(loc+f.Offset, Field{f.Kind, 0, joinNames(name, f.Name), f.BaseType})
+			add(loc+f.Offset, Field{f.Kind, 0, joinNames(name, f.Name), f.BaseType})
 		}
 	}
+	h := new(heap)
+	for addr, f := range m {
+		h.Insert(addr, f)
+	}
 	return h
 }
 
+// moreSpecific reports whether global field f should name its address
+// in preference to g.  Typed variables beat the untyped (~) ones, then
+// longer names, which are fields nested deeper, beat shorter ones.
+func moreSpecific(f, g Field) bool {
+	fu, gu := strings.HasPrefix(f.Name, "~"), strings.HasPrefix(g.Name, "~")
+	if fu != gu {
+		return gu
+	}
+	if len(f.Name) != len(g.Name) {
+		return len(f.Name) > len(g.Name)
+	}
+	return f.Name < g.Name
+}
+
 // stack frames may be zero-sized, so we add call depth
 // to the key to ensure uniqueness.
 type frameKey struct {
@@ -1223,13 +1877,14 @@ type frameKey struct {
 }
 
 // appendEdge might add an edge to edges.  Returns new edges.
-//   Requires data[off:] be a pointer
-//   Adds an edge if that pointer points to a valid object.
+//
+//	Requires data[off:] be a pointer
+//	Adds an edge if that pointer points to a valid object.
 func (d *Dump) appendEdge(edges []Edge, data []byte, off uint64, f Field) []Edge {
-	p := readPtr(d, data[off:])
+	p := d.ReadPtr(data[off:])
 	q := d.FindObj(p)
 	if q != ObjNil {
-		edges = append(edges, Edge{q, off, p - d.objects[q].Addr, f.Name})
+		edges = append(edges, Edge{q, off, p - d.objAddr[q], f.Name, f.Kind, false})
 	}
 	return edges
 }
@@ -1250,7 +1905,7 @@ func (d *Dump) appendFields(edges []Edge, data []byte, fields []Field) []Edge {
 			edges = d.appendEdge(edges, data, off, f)
 		case FieldKindEface:
 			edges = d.appendEdge(edges, data, off, f)
-			tp := readPtr(d, data[off:])
+			tp := d.ReadPtr(data[off:])
 			if tp != 0 {
 				t := d.TypeMap[tp]
 				if t == nil {
@@ -1262,7 +1917,7 @@ func (d *Dump) appendFields(edges []Edge, data []byte, fields []Field) []Edge {
 				}
 			}
 		case FieldKindIface:
-			tp := readPtr(d, data[off:])
+			tp := d.ReadPtr(data[off:])
 			if tp != 0 {
 				if d.ItabMap[tp] {
 					edges = d.appendEdge(edges, data, off+d.PtrSize, f)
@@ -1283,12 +1938,18 @@ func nameWithDwarf(d *Dump, execname string) {
 	for _, x := range t {
 		m[x.Name()] = x
 	}
+	d.Dwarf.Types = len(d.Types)
 	for _, t := range d.Types {
+		if SkipNaming != nil && SkipNaming.MatchString(t.Name) {
+			d.Dwarf.Skipped++
+			continue
+		}
 		dt := m[t.Name]
 		if dt == nil {
 			// A type in the dump has no entry in the Dwarf info.
 			// This can happen for unexported types, e.g. reflect.ptrGC.
 			//log.Printf("type %s has no dwarf info", t.Name)
+			d.Dwarf.Missing++
 			continue
 		}
 		// Check that the Dwarf type is consistent with the type we got from
@@ -1308,7 +1969,7 @@ func nameWithDwarf(d *Dump, execname string) {
 		// in both kind and offset.
 		for _, f := range t.Fields {
 			if layout[f.Offset].Kind != f.Kind {
-				log.Printf("dwarf field kind doesn't match dump kind %s.%d dwarf=%d dump=%d", t.Name, f.Offset, layout[f.Offset].Kind, f.Kind)
+				DwarfLog.Printf("dwarf field kind doesn't match dump kind %s.%d dwarf=%d dump=%d", t.Name, f.Offset, layout[f.Offset].Kind, f.Kind)
 				consistent = false
 			}
 			delete(layout, f.Offset)
@@ -1317,7 +1978,7 @@ func nameWithDwarf(d *Dump, execname string) {
 		for _, f := range layout {
 			switch f.Kind {
 			case FieldKindPtr, FieldKindString, FieldKindSlice, FieldKindIface, FieldKindEface:
-				log.Printf("dwarf type has additional ptr field %s %d %d", f.Name, f.Offset, f.Kind)
+				DwarfLog.Printf("dwarf type has additional ptr field %s %d %d", f.Name, f.Offset, f.Kind)
 				consistent = false
 			}
 		}
@@ -1325,8 +1986,10 @@ func nameWithDwarf(d *Dump, execname string) {
 			// Dwarf info looks good, overwrite the fields from the dump
 			// with fields from the Dwarf info.
 			t.Fields = df
+			d.Dwarf.Named++
 		} else {
-			log.Print("inconsistent type for", t.Name)
+			DwarfLog.Print("inconsistent type for ", t.Name)
+			d.Dwarf.Inconsistent++
 		}
 	}
 
@@ -1340,8 +2003,9 @@ func nameWithDwarf(d *Dump, execname string) {
 		if f.Depth == 0 {
 			continue
 		}
-		g := frames[frameKey{f.childaddr, f.Depth - 1}]
-		g.Parent = f
+		if g := frames[frameKey{f.childaddr, f.Depth - 1}]; g != nil {
+			g.Parent = f
+		}
 	}
 	for _, g := range d.Goroutines {
 		g.Bos = frames[frameKey{g.bosaddr, 0}]
@@ -1391,18 +2055,46 @@ func nameWithDwarf(d *Dump, execname string) {
 
 func link(d *Dump) {
 	// sort objects in increasing address order
-	sort.Sort(byAddr(d.objects))
+	sort.Sort(byAddr{d})
+
+	// index full types by name
+	d.ftByName = map[string][]*FullType{}
+	for _, ft := range d.FTList {
+		name := ft.Name
+		if ft.Typ != nil {
+			name = ft.Typ.Name
+		}
+		d.ftByName[name] = append(d.ftByName[name], ft)
+	}
+
+	// Check the layout of the objects.  An object extending outside
+	// the heap, or overlapping the next one, means a corrupt dump or a
+	// parser bug.  Validate reports them.
+	for i := 0; i < d.NumObjects(); i++ {
+		end := d.objAddr[i] + d.Size(ObjId(i))
+		if d.objAddr[i] < d.HeapStart || end > d.HeapEnd {
+			d.outside = append(d.outside, ObjId(i))
+		} else if i+1 < d.NumObjects() && end > d.objAddr[i+1] {
+			d.overlaps = append(d.overlaps, ObjId(i))
+		}
+	}
 
 	// initialize index array
 	d.idx = make([]ObjId, (d.HeapEnd-d.HeapStart+bucketSize-1)/bucketSize)
 	for i := len(d.idx) - 1; i >= 0; i-- {
-		d.idx[i] = ObjId(len(d.objects))
+		d.idx[i] = ObjId(d.NumObjects())
 	}
-	for i := len(d.objects) - 1; i >= 0; i-- {
+	for i := d.NumObjects() - 1; i >= 0; i-- {
 		// Note: we iterate in reverse order so that the object with
 		// the lowest address that intersects a bucket will win.
-		lo := (d.objects[i].Addr - d.HeapStart) / bucketSize
-		hi := (d.objects[i].Addr + d.objects[i].Ft.Size - 1 - d.HeapStart) / bucketSize
+		if d.objAddr[i] < d.HeapStart || d.objAddr[i] >= d.HeapEnd {
+			continue
+		}
+		lo := (d.objAddr[i] - d.HeapStart) / bucketSize
+		hi := (d.objAddr[i] + d.Size(ObjId(i)) - 1 - d.HeapStart) / bucketSize
+		if hi >= uint64(len(d.idx)) {
+			hi = uint64(len(d.idx)) - 1
+		}
 		for j := lo; j <= hi; j++ {
 			d.idx[j] = ObjId(i)
 		}
@@ -1424,22 +2116,58 @@ func link(d *Dump) {
 		if f.Depth == 0 {
 			continue
 		}
-		g := frames[frameKey{f.childaddr, f.Depth - 1}]
-		g.Parent = f
+		if g := frames[frameKey{f.childaddr, f.Depth - 1}]; g != nil {
+			g.Parent = f
+		}
 	}
 
-	// link goroutines to frames & vice versa
+	// link goroutines to frames & vice versa.  A goroutine whose
+	// bottom frame is missing (a stale goroutine, or one whose stack
+	// memory was reused) is dropped instead of failing the whole load.
+	gs := d.Goroutines[:0]
 	for _, g := range d.Goroutines {
 		g.Bos = frames[frameKey{g.bosaddr, 0}]
 		if g.Bos == nil {
-			log.Fatal("bos missing")
+			log.Printf("goroutine %d: bottom of stack frame at %x missing, dropping it", g.Goid, g.bosaddr)
+			continue
 		}
+		gs = append(gs, g)
 		for f := g.Bos; f != nil; f = f.Parent {
 			f.Goroutine = g
 		}
-		x := d.FindObj(g.ctxtaddr)
-		if x != ObjNil {
-			g.Ctxt = x
+		g.Ctxt = d.FindObj(g.ctxtaddr)
+	}
+	d.Goroutines = gs
+
+	// link goroutines to their defer and panic chains.  The chains
+	// are followed no further than the number of records, in case
+	// the dump has a cycle.
+	defers := map[uint64]*Defer{}
+	for _, x := range d.Defers {
+		defers[x.Addr] = x
+	}
+	panics := map[uint64]*Panic{}
+	for _, x := range d.Panics {
+		panics[x.Addr] = x
+	}
+	for _, g := range d.Goroutines {
+		for a := g.deferaddr; a != 0 && len(g.defers) < len(d.Defers); {
+			x := defers[a]
+			if x == nil {
+				d.warnOnce("can't find defer record", a)
+				break
+			}
+			g.defers = append(g.defers, x)
+			a = x.link
+		}
+		for a := g.panicaddr; a != 0 && len(g.panics) < len(d.Panics); {
+			x := panics[a]
+			if x == nil {
+				d.warnOnce("can't find panic record", a)
+				break
+			}
+			g.panics = append(g.panics, x)
+			a = x.link
 		}
 	}
 
@@ -1452,7 +2180,7 @@ func link(d *Dump) {
 	for _, r := range d.Otherroots {
 		x := d.FindObj(r.toaddr)
 		if x != ObjNil {
-			r.Edges = append(r.Edges, Edge{x, 0, r.toaddr - d.objects[x].Addr, ""})
+			r.Edges = append(r.Edges, Edge{x, 0, r.toaddr - d.objAddr[x], "", FieldKindEol, false})
 		}
 	}
 
@@ -1473,7 +2201,7 @@ func link(d *Dump) {
 		for _, addr := range []uint64{f.obj, f.fn, f.fint, f.ot} {
 			x := d.FindObj(addr)
 			if x != ObjNil {
-				f.Edges = append(f.Edges, Edge{x, 0, addr - d.objects[x].Addr, ""})
+				f.Edges = append(f.Edges, Edge{x, 0, addr - d.objAddr[x], "", FieldKindEol, false})
 			}
 		}
 	}
@@ -1546,7 +2274,7 @@ func nameFullTypes(d *Dump) {
 				case 8:
 					ft.Fields = append(ft.Fields, Field{FieldKindBytes8, i, fmt.Sprintf("offset %x", i), ""})
 				default:
-					log.Fatalf("weird size obj", ft.Size)
+					log.Fatalf("weird size obj %d", ft.Size)
 				}
 			}
 		case ft.Typ != nil && ft.Kind == TypeKindObject:
@@ -1599,32 +2327,114 @@ func nameFullTypes(d *Dump) {
 	}
 }
 
-type byAddr []object
+// byAddr sorts the objects of a Dump by address.
+type byAddr struct {
+	d *Dump
+}
+
+func (a byAddr) Len() int { return a.d.NumObjects() }
+func (a byAddr) Swap(i, j int) {
+	d := a.d
+	d.objAddr[i], d.objAddr[j] = d.objAddr[j], d.objAddr[i]
+	d.objFt[i], d.objFt[j] = d.objFt[j], d.objFt[i]
+	d.objOffset[i], d.objOffset[j] = d.objOffset[j], d.objOffset[i]
+}
+func (a byAddr) Less(i, j int) bool { return a.d.objAddr[i] < a.d.objAddr[j] }
 
-func (a byAddr) Len() int           { return len(a) }
-func (a byAddr) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byAddr) Less(i, j int) bool { return a[i].Addr < a[j].Addr }
+type byOffset []Field
 
-func Read(dumpname, execname string) *Dump {
-	d := rawRead(dumpname)
+func (a byOffset) Len() int           { return len(a) }
+func (a byOffset) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byOffset) Less(i, j int) bool { return a[i].Offset < a[j].Offset }
+
+// Read reads the heap dump in the file dumpname.  If execname is not
+// empty, the DWARF info in that executable is used to name types and
+// fields.
+func Read(dumpname, execname string) (*Dump, error) {
+	d, err := rawRead(dumpname)
+	if err != nil {
+		return nil, err
+	}
+	process(d, execname)
+	return d, nil
+}
+
+// ReadFrom reads a heap dump from r, parsing it as it arrives.  It is
+// for dumps which can't be read from a file, for instance because they
+// are written to a pipe.  The contents of the dump are kept in memory.
+func ReadFrom(r io.Reader, execname string) (*Dump, error) {
+	var buf bytes.Buffer
+	d := &Dump{Time: time.Now()}
+	if err := readRecords(io.TeeReader(r, &buf), d, d.addObject); err != nil {
+		return nil, err
+	}
+	d.r = bytes.NewReader(buf.Bytes())
+	process(d, execname)
+	return d, nil
+}
+
+// process does everything needed to get a Dump ready to use after
+// its records have been read.
+func process(d *Dump, execname string) {
 	if execname != "" {
+		checkExecutable(d, execname)
+		loadSymbols(d, execname)
 		nameWithDwarf(d, execname)
+		if LoadRodata {
+			loadRodata(d, execname)
+		}
 	} else {
 		nameFallback(d)
 	}
 	nameFullTypes(d)
 	link(d)
-	return d
 }
 
-func readPtr(d *Dump, b []byte) uint64 {
+// FullTypesByName returns the full types whose base type is named
+// name: the plain objects, arrays and channels of that type.  Types
+// without a base type, such as noptr16, are found by their own name.
+// The result must not be modified.
+func (d *Dump) FullTypesByName(name string) []*FullType {
+	return d.ftByName[name]
+}
+
+// warnOnce logs a problem with the dump, found at address addr, the
+// first time it is seen.  Problems which can be worked around, and
+// which would otherwise be reported for every object, go here.
+func (d *Dump) warnOnce(msg string, addr uint64) {
+	if d.warned[msg] {
+		return
+	}
+	if d.warned == nil {
+		d.warned = map[string]bool{}
+	}
+	d.warned[msg] = true
+	log.Printf("%s %x; further instances not reported", msg, addr)
+}
+
+// ReadPtr decodes a pointer-sized value from the start of b using the
+// dump's byte order and pointer size.
+func (d *Dump) ReadPtr(b []byte) uint64 {
 	switch d.PtrSize {
 	case 4:
 		return uint64(d.Order.Uint32(b))
 	case 8:
 		return d.Order.Uint64(b)
 	default:
-		log.Fatal("unsupported PtrSize=%d", d.PtrSize)
+		log.Fatalf("unsupported PtrSize=%d", d.PtrSize)
 		return 0
 	}
 }
+
+// WritePtr encodes v as a pointer-sized value at the start of b using
+// the dump's byte order and pointer size.
+func (d *Dump) WritePtr(b []byte, v uint64) {
+	switch d.PtrSize {
+	case 4:
+		d.Order.PutUint32(b, uint32(v))
+	case 8:
+		d.Order.PutUint64(b, v)
+	default:
+		log.Fatalf("unsupported PtrSize=%d", d.PtrSize)
+	}
+}
@@ -0,0 +1,154 @@
+package read
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// FuzzRawRead checks that rawRead reports malformed dumps as errors
+// rather than crashing.
+func FuzzRawRead(f *testing.F) {
+	w := newTestDump()
+	w.Header(0x10000, 0x11000)
+	w.Type(0x100, 16, "main.T", 0)
+	w.Object(0x10000, 0x100, TypeKindObject, w.Words(0x10010, 0))
+	w.Object(0x10010, 0x100, TypeKindArray, w.Words(0, 0))
+	w.OtherRoot("root", 0x10000)
+	w.End()
+	f.Add(w.Bytes()[len(dumpHeader):])
+
+	// objects whose type can't be named
+	for _, kind := range []TypeKind{TypeKindArray, TypeKindChan} {
+		w := newTestDump()
+		w.Object(0x10000, 0, kind, w.Words(0, 0))
+		f.Add(w.Bytes())
+	}
+	w = newTestDump()
+	w.Type(0x100, 0, "struct {}")
+	w.Object(0x10000, 0x100, TypeKindArray, w.Words(0))
+	f.Add(w.Bytes())
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		name := filepath.Join(t.TempDir(), "dump")
+		if err := os.WriteFile(name, append([]byte(dumpHeader), b...), 0666); err != nil {
+			t.Fatal(err)
+		}
+		d, err := rawRead(name)
+		if err == nil {
+			d.r.(io.Closer).Close()
+		}
+	})
+}
+
+// TestHugeType checks that the bytes in a type whose instances total
+// more than 4GB are counted without wrapping.
+func TestHugeType(t *testing.T) {
+	const (
+		heapStart = 0x10000
+		size      = 3 << 29 // 1.5GB
+		n         = 3
+	)
+	w := newTestDump()
+	w.Header(heapStart, heapStart+n*size)
+	for i := uint64(0); i < n; i++ {
+		w.HugeObject(heapStart+i*size, size)
+		w.OtherRoot("root", heapStart+i*size)
+	}
+	w.End()
+	name := filepath.Join(t.TempDir(), "dump")
+	if err := w.WriteFile(name); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Read(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.NumObjects() != n {
+		t.Fatalf("got %d objects, want %d", d.NumObjects(), n)
+	}
+
+	const want = n * size
+	if got := d.TotalBytes(); got != want {
+		t.Errorf("TotalBytes() = %d, want %d", got, want)
+	}
+	if got := d.LiveBytes(); got != want {
+		t.Errorf("LiveBytes() = %d, want %d", got, want)
+	}
+	ft := d.Ft(0)
+	var total uint64
+	for i := 0; i < n; i++ {
+		x := ObjId(i)
+		if d.Ft(x) != ft {
+			t.Fatalf("object %d has type %s, want %s", x, d.Ft(x).Name, ft.Name)
+		}
+		total += d.Size(x)
+	}
+	if total != want {
+		t.Errorf("sizes of the instances add up to %d, want %d", total, want)
+	}
+	if got := d.RetainedByType()[ft.Id]; got != want {
+		t.Errorf("RetainedByType()[%s] = %d, want %d", ft.Name, got, want)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	// Object 0 points to objects 1, 2, 3 and 1 again.
+	d := graphDump(t, [][]int{{1, 2, 3, 1}, nil, nil, nil}, 0)
+	for _, tt := range []struct {
+		size  uint64
+		bytes int
+		edges []ObjId
+	}{
+		{0, 0, nil},
+		{7, 7, nil},
+		{8, 8, []ObjId{1}},
+		{20, 20, []ObjId{1, 2}},
+		{nodeSize, nodeSize, []ObjId{1, 2, 3, 1}},
+		{1 << 20, nodeSize, []ObjId{1, 2, 3, 1}},
+	} {
+		if b := d.ContentsPrefix(0, tt.size); len(b) != tt.bytes {
+			t.Errorf("ContentsPrefix(0, %d) has %d bytes, want %d", tt.size, len(b), tt.bytes)
+		}
+		var got []ObjId
+		for _, e := range d.EdgesPrefix(0, tt.size) {
+			got = append(got, e.To)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.edges) {
+			t.Errorf("EdgesPrefix(0, %d) goes to %v, want %v", tt.size, got, tt.edges)
+		}
+	}
+}
+
+func TestFullTypesByName(t *testing.T) {
+	w := newTestDump()
+	w.Header(0x10000, 0x11000)
+	w.Type(0x100, 16, "main.T", 0)
+	w.Object(0x10000, 0x100, TypeKindObject, w.Words(0, 0))
+	w.Object(0x10010, 0x100, TypeKindArray, w.Words(0, 0, 0, 0))
+	w.Object(0x10030, 0, TypeKindObject, w.Words(0, 0))
+	w.OtherRoot("root", 0x10000)
+	w.End()
+	d, err := ReadFrom(bytes.NewReader(w.Bytes()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]string{
+		"main.T":  {"main.T", "{2}main.T"},
+		"noptr16": {"noptr16"},
+		"main.U":  nil,
+	} {
+		var got []string
+		for _, ft := range d.FullTypesByName(name) {
+			got = append(got, ft.Name)
+		}
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("FullTypesByName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
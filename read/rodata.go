@@ -0,0 +1,64 @@
+package read
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+)
+
+// LoadRodata, if set, makes Read keep a copy of the executable's
+// read-only data section so that Rodata can show what non-heap
+// pointers into it point at, string constants in particular.
+var LoadRodata bool
+
+// loadRodata reads the read-only data section of the executable.  It
+// leaves d.rodata nil if the executable doesn't have one we recognize.
+func loadRodata(d *Dump, execname string) {
+	if e, err := elf.Open(execname); err == nil {
+		defer e.Close()
+		if s := e.Section(".rodata"); s != nil {
+			if b, err := s.Data(); err == nil {
+				d.rodataAddr, d.rodata = s.Addr, b
+			}
+		}
+		return
+	}
+	if m, err := macho.Open(execname); err == nil {
+		defer m.Close()
+		if s := m.Section("__rodata"); s != nil {
+			if b, err := s.Data(); err == nil {
+				d.rodataAddr, d.rodata = s.Addr, b
+			}
+		}
+		return
+	}
+	if p, err := pe.Open(execname); err == nil {
+		defer p.Close()
+		var base uint64
+		switch h := p.OptionalHeader.(type) {
+		case *pe.OptionalHeader32:
+			base = uint64(h.ImageBase)
+		case *pe.OptionalHeader64:
+			base = h.ImageBase
+		}
+		if s := p.Section(".rdata"); s != nil {
+			if b, err := s.Data(); err == nil {
+				d.rodataAddr, d.rodata = base+uint64(s.VirtualAddress), b
+			}
+		}
+	}
+}
+
+// Rodata returns up to n bytes of the executable's read-only data
+// starting at address addr.  It returns nil if addr is not in that
+// section or if LoadRodata wasn't set when the dump was read.
+func (d *Dump) Rodata(addr, n uint64) []byte {
+	if addr < d.rodataAddr || addr >= d.rodataAddr+uint64(len(d.rodata)) {
+		return nil
+	}
+	b := d.rodata[addr-d.rodataAddr:]
+	if uint64(len(b)) > n {
+		b = b[:n]
+	}
+	return b
+}
@@ -0,0 +1,99 @@
+package read
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"sort"
+)
+
+// A Symbol is a function or variable in the executable.
+type Symbol struct {
+	Name string
+	Addr uint64
+	Size uint64
+}
+
+// loadSymbols reads the symbol table of the executable.  It leaves
+// d.syms nil if the executable doesn't have one we recognize.
+func loadSymbols(d *Dump, execname string) {
+	var syms []Symbol
+	if e, err := elf.Open(execname); err == nil {
+		defer e.Close()
+		ss, _ := e.Symbols()
+		for _, s := range ss {
+			switch elf.ST_TYPE(s.Info) {
+			case elf.STT_FUNC, elf.STT_OBJECT:
+				if s.Value != 0 && s.Section != elf.SHN_UNDEF {
+					syms = append(syms, Symbol{s.Name, s.Value, s.Size})
+				}
+			}
+		}
+	} else if m, err := macho.Open(execname); err == nil {
+		defer m.Close()
+		if m.Symtab != nil {
+			for _, s := range m.Symtab.Syms {
+				// skip debugging entries and undefined symbols
+				if s.Type&0xe0 != 0 || s.Sect == 0 {
+					continue
+				}
+				syms = append(syms, Symbol{s.Name, s.Value, 0})
+			}
+		}
+	} else if p, err := pe.Open(execname); err == nil {
+		defer p.Close()
+		var base uint64
+		switch h := p.OptionalHeader.(type) {
+		case *pe.OptionalHeader32:
+			base = uint64(h.ImageBase)
+		case *pe.OptionalHeader64:
+			base = h.ImageBase
+		}
+		for _, s := range p.Symbols {
+			if s.SectionNumber <= 0 || int(s.SectionNumber) > len(p.Sections) {
+				continue
+			}
+			sect := p.Sections[s.SectionNumber-1]
+			syms = append(syms, Symbol{s.Name, base + uint64(sect.VirtualAddress) + uint64(s.Value), 0})
+		}
+	}
+	sort.Stable(bySymAddr(syms))
+
+	// Mach-O and PE symbols have no size.  Assume they run to the
+	// next symbol.
+	for i := range syms {
+		if syms[i].Size == 0 && i+1 < len(syms) {
+			syms[i].Size = syms[i+1].Addr - syms[i].Addr
+		}
+	}
+	d.syms = syms
+}
+
+// Symbols returns the symbols of the executable, sorted by address.
+// It is empty if no executable was given.  The result must not be
+// modified.
+func (d *Dump) Symbols() []Symbol {
+	return d.syms
+}
+
+// SymbolAt returns the symbol containing address addr, and the offset
+// of addr within it.  ok is false if there is no such symbol.
+func (d *Dump) SymbolAt(addr uint64) (name string, off uint64, ok bool) {
+	// find the last symbol starting at or before addr
+	i := sort.Search(len(d.syms), func(i int) bool { return d.syms[i].Addr > addr }) - 1
+	if i < 0 {
+		return "", 0, false
+	}
+	s := d.syms[i]
+	off = addr - s.Addr
+	if off >= s.Size && !(off == 0 && s.Size == 0) {
+		return "", 0, false
+	}
+	return s.Name, off, true
+}
+
+type bySymAddr []Symbol
+
+func (a bySymAddr) Len() int           { return len(a) }
+func (a bySymAddr) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a bySymAddr) Less(i, j int) bool { return a[i].Addr < a[j].Addr }
@@ -0,0 +1,155 @@
+package read
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// dumpHeader is the first line of every heap dump.
+const dumpHeader = "go1.3 heap dump\n"
+
+// A TestDump builds a synthetic heap dump record by record, for the
+// tests of this package and of the tools built on it.
+type TestDump struct {
+	dumpWriter
+	order   binary.ByteOrder
+	ptrSize uint64
+	buf     bytes.Buffer
+	holes   []hole
+}
+
+// A hole is a run of n zero bytes at offset off in the dump which
+// isn't in buf.  WriteFile leaves it as a hole in the file.
+type hole struct {
+	off int
+	n   int64
+}
+
+// NewTestDump returns an empty dump for a machine with the given byte
+// order and pointer size.
+func NewTestDump(order binary.ByteOrder, ptrSize uint64) *TestDump {
+	t := &TestDump{order: order, ptrSize: ptrSize}
+	t.w = bufio.NewWriter(&t.buf)
+	return t
+}
+
+// Header writes the dump header and a params record for a heap at
+// [heapStart,heapEnd).
+func (t *TestDump) Header(heapStart, heapEnd uint64) {
+	t.w.WriteString(dumpHeader)
+	t.uint64(tagParams)
+	if t.order == binary.BigEndian {
+		t.uint64(1)
+	} else {
+		t.uint64(0)
+	}
+	t.uint64(t.ptrSize)
+	t.uint64(96)
+	t.uint64(heapStart)
+	t.uint64(heapEnd)
+	t.uint64('6')
+	t.string("")
+	t.uint64(1) // ncpu
+}
+
+// Type writes a type record for a type with pointers at offsets ptrs.
+func (t *TestDump) Type(addr, size uint64, name string, ptrs ...uint64) {
+	t.uint64(tagType)
+	t.uint64(addr)
+	t.uint64(size)
+	t.string(name)
+	t.bool(false)
+	var f []Field
+	for _, off := range ptrs {
+		f = append(f, Field{Kind: FieldKindPtr, Offset: off})
+	}
+	t.fields(f)
+}
+
+// Object writes an object record of the given type and contents.
+func (t *TestDump) Object(addr, typaddr uint64, kind TypeKind, contents []byte) {
+	t.uint64(tagObject)
+	t.uint64(addr)
+	t.uint64(typaddr)
+	t.uint64(uint64(kind))
+	t.bytes(contents)
+}
+
+// HugeObject writes an object record for a pointer-free object of
+// size bytes, leaving its contents as a hole.
+func (t *TestDump) HugeObject(addr, size uint64) {
+	t.uint64(tagObject)
+	t.uint64(addr)
+	t.uint64(0)
+	t.uint64(uint64(TypeKindObject))
+	t.uint64(size)
+	t.w.Flush()
+	t.holes = append(t.holes, hole{t.buf.Len(), int64(size)})
+}
+
+// OtherRoot writes a root pointing to addr.
+func (t *TestDump) OtherRoot(desc string, addr uint64) {
+	t.uint64(tagOtherRoot)
+	t.string(desc)
+	t.uint64(addr)
+}
+
+// End writes empty data and bss sections, the memory statistics, and
+// the EOF record.
+func (t *TestDump) End() {
+	for _, tag := range []uint64{tagData, tagBss} {
+		t.uint64(tag)
+		t.uint64(0)
+		t.bytes(nil)
+		t.fields(nil)
+	}
+	t.uint64(tagMemStats)
+	for i := 0; i < 25+256; i++ {
+		t.uint64(0)
+	}
+	t.uint64(tagEOF)
+}
+
+// Words returns x encoded as pointer-sized words in the dump's byte
+// order.
+func (t *TestDump) Words(x ...uint64) []byte {
+	b := make([]byte, t.ptrSize*uint64(len(x)))
+	for i, v := range x {
+		if t.ptrSize == 4 {
+			t.order.PutUint32(b[4*i:], uint32(v))
+		} else {
+			t.order.PutUint64(b[8*i:], v)
+		}
+	}
+	return b
+}
+
+// Bytes returns the records written so far, without their holes.
+func (t *TestDump) Bytes() []byte {
+	t.w.Flush()
+	return t.buf.Bytes()
+}
+
+// WriteFile writes the dump to the named file, leaving its holes as
+// holes in the file, so huge objects take no disk space.
+func (t *TestDump) WriteFile(name string) error {
+	b := t.Bytes()
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	off := 0
+	for _, h := range t.holes {
+		f.Write(b[off:h.off])
+		f.Seek(h.n, io.SeekCurrent)
+		off = h.off
+	}
+	if _, err := f.Write(b[off:]); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,68 @@
+package read
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Validate checks that every object lies inside the heap without
+// overlapping the next one, and that every edge in the dump lands
+// inside the object it points to, that is, that Addr(e.To)+e.ToOffset
+// is in [Addr(e.To), Addr(e.To)+Size(e.To)).  Either problem points to
+// a corrupt dump or a parser bug, in FindObj for instance.  It returns
+// an error describing the first of each kind of problem and how many
+// there are.
+func (d *Dump) Validate() error {
+	var errs []string
+	if len(d.outside) > 0 {
+		x := d.outside[0]
+		errs = append(errs, fmt.Sprintf("%d objects extend outside the heap [%x,%x); first: %x, size %d", len(d.outside), d.HeapStart, d.HeapEnd, d.Addr(x), d.Size(x)))
+	}
+	if len(d.overlaps) > 0 {
+		x := d.overlaps[0]
+		errs = append(errs, fmt.Sprintf("%d objects overlap the next object; first: %x, size %d, next at %x", len(d.overlaps), d.Addr(x), d.Size(x), d.Addr(x+1)))
+	}
+
+	var first string
+	n := 0
+	// check counts the bad edges among edges.  from describes where
+	// they come from, and is only called for the first bad one.
+	check := func(edges []Edge, from func() string) {
+		for _, e := range edges {
+			if e.To >= 0 && int(e.To) < d.NumObjects() && e.ToOffset < d.Size(e.To) {
+				continue
+			}
+			if n == 0 {
+				if e.To < 0 || int(e.To) >= d.NumObjects() {
+					first = fmt.Sprintf("edge from %s at offset %d points to object %d, which doesn't exist", from(), e.FromOffset, e.To)
+				} else {
+					first = fmt.Sprintf("edge from %s at offset %d points to %x+%d, past the end of the object (size %d)", from(), e.FromOffset, d.Addr(e.To), e.ToOffset, d.Size(e.To))
+				}
+			}
+			n++
+		}
+	}
+	for _, f := range d.Frames {
+		check(f.Edges, func() string { return fmt.Sprintf("frame %s at %x", f.Name, f.Addr) })
+	}
+	for _, x := range []*Data{d.Data, d.Bss} {
+		check(x.Edges, func() string { return fmt.Sprintf("globals at %x", x.Addr) })
+	}
+	for _, r := range d.Otherroots {
+		check(r.Edges, func() string { return "root " + r.Description })
+	}
+	for _, f := range d.QFinal {
+		check(f.Edges, func() string { return "queued finalizer" })
+	}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := ObjId(i)
+		check(d.Edges(x), func() string { return fmt.Sprintf("object %x", d.Addr(x)) })
+	}
+	if n > 0 {
+		errs = append(errs, fmt.Sprintf("%d bad edges; first: %s", n, first))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
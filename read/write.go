@@ -0,0 +1,226 @@
+package read
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// dumpWriter writes the primitive values of the heap dump format.
+type dumpWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (w *dumpWriter) uint64(x uint64) {
+	n := binary.PutUvarint(w.buf[:], x)
+	w.w.Write(w.buf[:n])
+}
+
+func (w *dumpWriter) bytes(b []byte) {
+	w.uint64(uint64(len(b)))
+	w.w.Write(b)
+}
+
+func (w *dumpWriter) string(s string) {
+	w.uint64(uint64(len(s)))
+	w.w.WriteString(s)
+}
+
+func (w *dumpWriter) bool(b bool) {
+	if b {
+		w.w.WriteByte(1)
+	} else {
+		w.w.WriteByte(0)
+	}
+}
+
+// fields writes the pointer-bearing fields of a field list.  Fields
+// named from the DWARF info may include scalars, which the dump
+// format never does.
+func (w *dumpWriter) fields(fields []Field) {
+	for _, f := range fields {
+		switch f.Kind {
+		case FieldKindPtr, FieldKindString, FieldKindSlice, FieldKindIface, FieldKindEface:
+			w.uint64(uint64(f.Kind))
+			w.uint64(f.Offset)
+		}
+	}
+	w.uint64(uint64(FieldKindEol))
+}
+
+// WriteSubDump writes to w a heap dump containing just object x and
+// the objects reachable from it, along with the types and itabs
+// needed to parse them.  x is the target of the dump's only root.
+// The result is useful as a small reproduction of a problem with a
+// large dump.
+func (d *Dump) WriteSubDump(w io.Writer, x ObjId) error {
+	// find the objects reachable from x
+	keep := map[ObjId]bool{x: true}
+	objs := []ObjId{x}
+	for i := 0; i < len(objs); i++ {
+		for _, e := range d.Edges(objs[i]) {
+			if !keep[e.To] {
+				keep[e.To] = true
+				objs = append(objs, e.To)
+			}
+		}
+	}
+	sort.Sort(byObjId(objs))
+
+	// find the types and itabs they use
+	types := map[uint64]bool{}
+	itabs := map[uint64]bool{}
+	for _, y := range objs {
+		ft := d.Ft(y)
+		if ft.Typ != nil {
+			types[ft.Typ.Addr] = true
+		}
+		b := d.Contents(y)
+		for _, f := range ft.Fields {
+			switch f.Kind {
+			case FieldKindEface:
+				if t := d.ReadPtr(b[f.Offset:]); t != 0 {
+					types[t] = true
+				}
+			case FieldKindIface:
+				if t := d.ReadPtr(b[f.Offset:]); t != 0 {
+					if _, ok := d.ItabMap[t]; ok {
+						itabs[t] = true
+					}
+				}
+			}
+		}
+	}
+
+	dw := &dumpWriter{w: bufio.NewWriter(w)}
+	dw.w.WriteString("go1.3 heap dump\n")
+
+	dw.uint64(tagParams)
+	if d.Order == binary.LittleEndian {
+		dw.uint64(0)
+	} else {
+		dw.uint64(1)
+	}
+	dw.uint64(d.PtrSize)
+	dw.uint64(d.HChanSize)
+	dw.uint64(d.HeapStart)
+	dw.uint64(d.HeapEnd)
+	dw.uint64(uint64(d.TheChar))
+	dw.string(d.Experiment)
+	dw.uint64(d.Ncpu)
+
+	for _, t := range d.Types {
+		if !types[t.Addr] {
+			continue
+		}
+		dw.uint64(tagType)
+		dw.uint64(t.Addr)
+		dw.uint64(t.Size)
+		dw.string(t.Name)
+		dw.bool(t.efaceptr)
+		dw.fields(t.Fields)
+	}
+	for _, a := range sortedKeys(itabs) {
+		dw.uint64(tagItab)
+		dw.uint64(a)
+		dw.bool(d.ItabMap[a])
+	}
+
+	for _, y := range objs {
+		ft := d.Ft(y)
+		dw.uint64(tagObject)
+		dw.uint64(d.Addr(y))
+		if ft.Typ != nil {
+			dw.uint64(ft.Typ.Addr)
+		} else {
+			dw.uint64(0)
+		}
+		dw.uint64(uint64(ft.Kind))
+		dw.uint64(ft.Size)
+		dw.w.Write(d.Contents(y))
+	}
+
+	dw.uint64(tagOtherRoot)
+	dw.string("sub-dump root")
+	dw.uint64(d.Addr(x))
+
+	// empty globals
+	for _, tag := range []uint64{tagData, tagBss} {
+		dw.uint64(tag)
+		dw.uint64(0)
+		dw.bytes(nil)
+		dw.fields(nil)
+	}
+
+	// zero memstats: 24 scalars, the pause ring buffer, and NumGC
+	dw.uint64(tagMemStats)
+	for i := 0; i < 24+256+1; i++ {
+		dw.uint64(0)
+	}
+
+	dw.uint64(tagEOF)
+	return dw.w.Flush()
+}
+
+// csrMagic starts the output of WriteCSR.
+const csrMagic = "go heap csr\n"
+
+// WriteCSR writes the object graph to w in compressed sparse row form,
+// for graph tools that can't read heap dumps.  After csrMagic, all
+// values are little endian:
+//
+//	n, m     uint64       number of objects and of edges
+//	offsets  [n+1]uint64  edges of object i are targets[offsets[i]:offsets[i+1]]
+//	targets  [m]uint32    ObjIds the edges point to
+//	sizes    [n]uint64    object sizes
+//	addrs    [n]uint64    object addresses
+//
+// An object pointing to another from several fields has an edge for
+// each.  Roots are not included.
+func (d *Dump) WriteCSR(w io.Writer) error {
+	n := d.NumObjects()
+	offsets := make([]uint64, n+1)
+	var targets []uint32
+	for i := 0; i < n; i++ {
+		for _, e := range d.Edges(ObjId(i)) {
+			targets = append(targets, uint32(e.To))
+		}
+		offsets[i+1] = uint64(len(targets))
+	}
+	sizes := make([]uint64, n)
+	for i := range sizes {
+		sizes[i] = d.Size(ObjId(i))
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(csrMagic)
+	for _, x := range []interface{}{[]uint64{uint64(n), uint64(len(targets))}, offsets, targets, sizes, d.objAddr} {
+		if err := binary.Write(bw, binary.LittleEndian, x); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+type byObjId []ObjId
+
+func (a byObjId) Len() int           { return len(a) }
+func (a byObjId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byObjId) Less(i, j int) bool { return a[i] < a[j] }
+
+func sortedKeys(m map[uint64]bool) []uint64 {
+	var s []uint64
+	for k := range m {
+		s = append(s, k)
+	}
+	sort.Sort(uint64s(s))
+	return s
+}
+
+type uint64s []uint64
+
+func (a uint64s) Len() int           { return len(a) }
+func (a uint64s) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a uint64s) Less(i, j int) bool { return a[i] < a[j] }